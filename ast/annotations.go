@@ -0,0 +1,91 @@
+package ast
+
+import (
+	"encoding/json"
+
+	"avidbound.com/zego/ast/term"
+	"avidbound.com/zego/util"
+)
+
+// Scopes of annotations. Package annotations apply to the package of the
+// module they are given in and subpackages annotations to the package and
+// every package below it. Rule annotations apply to the rule they are given
+// on and document annotations to every rule of the same document.
+const (
+	AnnotationScopePackage     = "package"
+	AnnotationScopeSubpackages = "subpackages"
+	AnnotationScopeRule        = "rule"
+	AnnotationScopeDocument    = "document"
+)
+
+// Annotations holds the metadata of a package or rule given in a comment
+// block starting with # METADATA, e.g.
+//
+//	# METADATA
+//	# title: Allow admins
+//	# custom:
+//	#   control: AC-2
+//	allow := true { input.user.admin }
+type Annotations struct {
+	Location    *term.Location         `json:"-"`
+	Scope       string                 `json:"scope"`
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Authors     []*AuthorAnnotation    `json:"authors,omitempty"`
+	Entrypoint  bool                   `json:"entrypoint,omitempty"`
+	Schemas     []*SchemaAnnotation    `json:"schemas,omitempty"`
+	Custom      map[string]interface{} `json:"custom,omitempty"`
+}
+
+// AuthorAnnotation is an author of a package or rule, at least one of the
+// name and email is set.
+type AuthorAnnotation struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// SchemaAnnotation associates the document at Path, e.g. input.user, with a
+// schema. The schema is either a reference to a schema document, e.g.
+// schema.user, or given inline as its Definition.
+type SchemaAnnotation struct {
+	Path       term.Ref    `json:"-"`
+	Schema     term.Ref    `json:"-"`
+	Definition interface{} `json:"definition,omitempty"`
+}
+
+func (a *Annotations) Loc() *term.Location {
+	return a.Location
+}
+
+func (a *Annotations) SetLoc(l *term.Location) {
+	a.Location = l
+}
+
+// Value returns the annotations as an object, e.g.
+// {"scope": "rule", "title": "Allow admins"}.
+func (a *Annotations) Value() (term.Value, error) {
+	bs, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	var x interface{}
+	if err := util.UnmarshalJSON(bs, &x); err != nil {
+		return nil, err
+	}
+	return term.InterfaceToValue(x)
+}
+
+// MarshalJSON encodes the path and schema as strings, e.g.
+// {"path": "input.user", "schema": "schema.user"}.
+func (s *SchemaAnnotation) MarshalJSON() ([]byte, error) {
+	x := map[string]interface{}{
+		"path": s.Path.String(),
+	}
+	if s.Schema != nil {
+		x["schema"] = s.Schema.String()
+	}
+	if s.Definition != nil {
+		x["definition"] = s.Definition
+	}
+	return json.Marshal(x)
+}
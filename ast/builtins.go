@@ -0,0 +1,17 @@
+package ast
+
+// builtins holds the names of the built-in functions, e.g. time.now_ns.
+var builtins = map[string]struct{}{}
+
+// RegisterBuiltin records name as the name of a built-in function. The
+// functions themselves are implemented by the evaluator, which registers the
+// name of every function it implements.
+func RegisterBuiltin(name string) {
+	builtins[name] = struct{}{}
+}
+
+// IsBuiltin returns true if name is the name of a built-in function.
+func IsBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
@@ -0,0 +1,22 @@
+package ast
+
+import "avidbound.com/zego/ast/term"
+
+// Comment represents a comment in a module, e.g. # allow admins. The text
+// follows the comment marker up to the end of the line.
+type Comment struct {
+	Location *term.Location `json:"-"`
+	Text     []byte         `json:"text"`
+}
+
+func (c *Comment) Loc() *term.Location {
+	return c.Location
+}
+
+func (c *Comment) SetLoc(l *term.Location) {
+	c.Location = l
+}
+
+func (c *Comment) String() string {
+	return "#" + string(c.Text)
+}
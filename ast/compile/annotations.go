@@ -0,0 +1,159 @@
+package compile
+
+import (
+	"sort"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+// AnnotationsRef holds annotations and the path they apply to, e.g.
+// zego.a.allow for the annotations of the rule allow in package a.
+type AnnotationsRef struct {
+	Path        term.Ref
+	Annotations *ast.Annotations
+}
+
+// AnnotationSet indexes the annotations of the modules by the path of the
+// package or document they apply to. Paths begin with the root document.
+type AnnotationSet struct {
+	rules       map[*ast.Rule]*AnnotationsRef
+	documents   map[string]*AnnotationsRef
+	packages    map[string]*AnnotationsRef
+	subpackages map[string]*AnnotationsRef
+}
+
+func newAnnotationSet() *AnnotationSet {
+	return &AnnotationSet{
+		rules:       map[*ast.Rule]*AnnotationsRef{},
+		documents:   map[string]*AnnotationsRef{},
+		packages:    map[string]*AnnotationsRef{},
+		subpackages: map[string]*AnnotationsRef{},
+	}
+}
+
+// setAnnotationSet indexes the annotations of the packages and rules. Each
+// package, document and rule has at most one set of annotations per scope.
+func (c *Compiler) setAnnotationSet() {
+	as := newAnnotationSet()
+
+	for _, name := range c.sorted {
+		mod := c.Modules[name]
+		pkg := packagePath(mod.Package)
+		for _, a := range mod.Package.Annotations {
+			index := as.packages
+			if a.Scope == ast.AnnotationScopeSubpackages {
+				index = as.subpackages
+			}
+			c.addAnnotations(index, pkg.String(), &AnnotationsRef{Path: pkg, Annotations: a})
+		}
+
+		for _, rule := range mod.Rules {
+			path := append(append(term.Ref{}, pkg...), term.StringTerm(string(rule.Name)))
+			for _, a := range rule.Annotations {
+				ref := &AnnotationsRef{Path: path, Annotations: a}
+				if a.Scope == ast.AnnotationScopeDocument {
+					c.addAnnotations(as.documents, path.String(), ref)
+					continue
+				}
+				if _, ok := as.rules[rule]; ok {
+					c.errorf(a.Location, "duplicate %s annotations for %v", a.Scope, path)
+					continue
+				}
+				as.rules[rule] = ref
+			}
+		}
+	}
+
+	c.Annotations = as
+}
+
+func (c *Compiler) addAnnotations(index map[string]*AnnotationsRef, key string, ref *AnnotationsRef) {
+	if _, ok := index[key]; ok {
+		c.errorf(ref.Annotations.Location, "duplicate %s annotations for %v", ref.Annotations.Scope, ref.Path)
+		return
+	}
+	index[key] = ref
+}
+
+// packagePath returns the path of the package beginning with the root
+// document, e.g. zego.a.b.
+func packagePath(pkg *ast.Package) term.Ref {
+	return append(term.Ref{ast.RootDocument}, pkg.Path...)
+}
+
+// Flatten returns all annotations of the set sorted by path. Annotations of
+// the same path are sorted by scope from the widest to the narrowest one.
+func (as *AnnotationSet) Flatten() []*AnnotationsRef {
+	var refs []*AnnotationsRef
+	for _, index := range []map[string]*AnnotationsRef{as.subpackages, as.packages, as.documents} {
+		for _, ref := range index {
+			refs = append(refs, ref)
+		}
+	}
+	for _, ref := range as.rules {
+		refs = append(refs, ref)
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		if cmp := refs[i].Path.Compare(refs[j].Path); cmp != 0 {
+			return cmp < 0
+		}
+		if a, b := scopeOrder[refs[i].Annotations.Scope], scopeOrder[refs[j].Annotations.Scope]; a != b {
+			return a < b
+		}
+		a, b := refs[i].Annotations.Location, refs[j].Annotations.Location
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Offset < b.Offset
+	})
+	return refs
+}
+
+var scopeOrder = map[string]int{
+	ast.AnnotationScopeSubpackages: 0,
+	ast.AnnotationScopePackage:     1,
+	ast.AnnotationScopeDocument:    2,
+	ast.AnnotationScopeRule:        3,
+}
+
+// Get returns the annotations that apply to exactly the package or document
+// at path, sorted like Flatten.
+func (as *AnnotationSet) Get(path term.Ref) []*AnnotationsRef {
+	var refs []*AnnotationsRef
+	for _, ref := range as.Flatten() {
+		if ref.Path.Equal(path) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// Chain returns the annotations that apply to the rule from the narrowest
+// scope to the widest one: the annotations of the rule, of its document, of
+// its package and those of the packages above it with the subpackages scope.
+func (as *AnnotationSet) Chain(rule *ast.Rule) []*AnnotationsRef {
+	var chain []*AnnotationsRef
+	if ref, ok := as.rules[rule]; ok {
+		chain = append(chain, ref)
+	}
+	if rule.Module == nil {
+		return chain
+	}
+
+	pkg := packagePath(rule.Module.Package)
+	path := append(append(term.Ref{}, pkg...), term.StringTerm(string(rule.Name)))
+	if ref, ok := as.documents[path.String()]; ok {
+		chain = append(chain, ref)
+	}
+	if ref, ok := as.packages[pkg.String()]; ok {
+		chain = append(chain, ref)
+	}
+	for ; len(pkg) > 1; pkg = pkg[:len(pkg)-1] {
+		if ref, ok := as.subpackages[pkg.String()]; ok {
+			chain = append(chain, ref)
+		}
+	}
+	return chain
+}
@@ -31,6 +31,10 @@ type Compiler struct {
 	//                 └─── b (1 rule)
 	RuleTree *TreeNode
 
+	// Annotations indexes the annotations of the packages and rules by the
+	// path they apply to.
+	Annotations *AnnotationSet
+
 	sorted  []string // list of sorted module names
 	Modules map[string]*ast.Module
 
@@ -46,16 +50,27 @@ func NewCompiler() *Compiler {
 	return c
 }
 
-func getGlobals(pkg *ast.Package, rules []term.Var) map[term.Var]term.Ref {
+func getGlobals(pkg *ast.Package, rules []term.Var, imports []*ast.Import) map[term.Var]term.Ref {
 	globals := map[term.Var]term.Ref{}
 
 	// Populate globals with exports within the package.
 	for _, v := range rules {
-		global := append(term.Ref{}, pkg.Path...)
+		global := term.Ref{ast.RootDocument}
+		global = append(global, pkg.Path...)
 		global = append(global, &term.Term{Value: term.String(v)})
 		globals[v] = global
 	}
 
+	// Populate globals with imports within the module.
+	for _, imp := range imports {
+		switch path := imp.Path.Value.(type) {
+		case term.Var:
+			globals[imp.Name()] = term.Ref{imp.Path}
+		case term.Ref:
+			globals[imp.Name()] = path
+		}
+	}
+
 	return globals
 }
 
@@ -71,14 +86,33 @@ func (c *Compiler) Compile(modules map[string]*ast.Module) {
 }
 
 func (c *Compiler) compile() {
+	stages := []func(){
+		c.resolveAllRefs,
+		c.setModuleTree,
+		c.setRuleTree,
+		c.checkRecursion,
+		c.setAnnotationSet,
+		c.checkRuleConflicts,
+		c.checkDefaultRules,
+		c.checkFunctionCalls,
+		c.checkWithModifiers,
+		c.checkSafetyRules,
+	}
 
-	c.resolveAllRefs()
-	c.setModuleTree()
-
+	for _, stage := range stages {
+		if stage(); c.Failed() {
+			return
+		}
+	}
 }
 
+// Failed returns true if errors were encountered while compiling.
 func (c *Compiler) Failed() bool {
-	return false
+	return len(c.Errors) > 0
+}
+
+func (c *Compiler) errorf(loc *term.Location, f string, a ...interface{}) {
+	c.Errors = append(c.Errors, ast.NewError(loc, f, a...))
 }
 
 func (c *Compiler) NewQueryCompiler() QueryCompiler {
@@ -93,7 +127,7 @@ func (c *Compiler) getExports() *util.HashMap {
 
 	rules := util.NewHashMap(func(a, b util.T) bool {
 		r1 := a.(term.Ref)
-		r2 := a.(term.Ref)
+		r2 := b.(term.Ref)
 		return r1.Equal(r2)
 	}, func(v util.T) int {
 		return v.(term.Ref).Hash()
@@ -121,10 +155,12 @@ func (c *Compiler) getExports() *util.HashMap {
 // For instance, given the following module:
 //
 // package a.b
-// import data.foo.bar
-// p[x] { bar[_] = x }
+// p := 1
+// q := x { x := p }
 //
-// The reference "bar[_]" would be resolved to "data.foo.bar[_]".
+// The reference "p" would be resolved to "zego.a.b.p". Imports are resolved in
+// the same way, given "import input.request as req" the reference "req.method"
+// would be resolved to "input.request.method".
 func (c *Compiler) resolveAllRefs() {
 
 	rules := c.getExports()
@@ -137,18 +173,78 @@ func (c *Compiler) resolveAllRefs() {
 			ruleExports = x.([]term.Var)
 		}
 
-		globals := getGlobals(mod.Package, ruleExports)
+		c.checkImports(mod, rules, ruleExports)
+
+		globals := getGlobals(mod.Package, ruleExports, mod.Imports)
+
+		for _, rule := range mod.Rules {
+			resolveRefsInRule(globals, rule)
+		}
+	}
+}
+
+// checkImports ensures that the imports of the module refer to known documents
+// and that every import is referred to by a unique name within the module.
+func (c *Compiler) checkImports(mod *ast.Module, exports *util.HashMap, rules []term.Var) {
+	names := map[term.Var]*ast.Import{}
+	for _, rule := range rules {
+		names[rule] = nil
+	}
+
+	for _, imp := range mod.Imports {
+		name := imp.Name()
+
+		if other, ok := names[name]; ok {
+			if other == nil {
+				c.errorf(imp.Location, "import %v conflicts with rule %v", imp.Path, name)
+			} else {
+				c.errorf(imp.Location, "import %v conflicts with %v", imp.Path, other)
+			}
+			continue
+		}
+		names[name] = imp
 
-		if globals == nil { // TEMPORARY
+		if ast.IsRootDocument(name) {
+			if !imp.Path.Value.Equal(name) {
+				c.errorf(imp.Location, "import %v cannot shadow %v", imp.Path, name)
+			}
+			continue
+		}
 
+		if ref, ok := imp.Path.Value.(term.Ref); ok && ref[0].Value.Equal(ast.RootDocument.Value) {
+			if !importExists(exports, ref[1:]) {
+				c.errorf(imp.Location, "unknown import %v", imp.Path)
+			}
 		}
 	}
 }
 
+// importExists returns true if path refers to a package, a part of a package
+// path or a document produced by a rule.
+func importExists(exports *util.HashMap, path term.Ref) bool {
+	return exports.Iter(func(k, v util.T) bool {
+		pkg := k.(term.Ref)
+		if pkg.HasPrefix(path) {
+			return true
+		}
+		for _, rule := range v.([]term.Var) {
+			doc := append(append(term.Ref{}, pkg...), term.StringTerm(string(rule)))
+			if path.HasPrefix(doc) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
 func (c *Compiler) setModuleTree() {
 	c.ModuleTree = NewModuleTree(c.Modules)
 }
 
+func (c *Compiler) setRuleTree() {
+	c.RuleTree = NewRuleTree(c.ModuleTree)
+}
+
 type ModuleTreeNode struct {
 	Key      term.Value // package path ie: rego.a.b
 	Modules  []*ast.Module
@@ -181,6 +277,90 @@ func NewModuleTree(mods map[string]*ast.Module) *ModuleTreeNode {
 
 type TreeNode struct {
 	Key      term.Value // rule path ie: rego.a.b
-	Values   []ast.Rule
+	Values   []*ast.Rule
 	Children map[term.Value]*TreeNode
 }
+
+// NewRuleTree returns a new TreeNode that represents the root of the rule
+// tree populated with the rules of the modules in the given module tree.
+func NewRuleTree(mtree *ModuleTreeNode) *TreeNode {
+	root := &TreeNode{
+		Children: map[term.Value]*TreeNode{},
+	}
+	root.add(ast.RootDocument.Value).addModules(mtree)
+	return root
+}
+
+func (n *TreeNode) addModules(mtree *ModuleTreeNode) {
+	for _, m := range mtree.Modules {
+		for _, rule := range m.Rules {
+			node := n.add(term.String(rule.Name))
+			node.Values = append(node.Values, rule)
+		}
+	}
+	for k, c := range mtree.Children {
+		n.add(k).addModules(c)
+	}
+}
+
+func (n *TreeNode) add(k term.Value) *TreeNode {
+	c, ok := n.Children[k]
+	if !ok {
+		c = &TreeNode{
+			Key:      k,
+			Children: map[term.Value]*TreeNode{},
+		}
+		n.Children[k] = c
+	}
+	return c
+}
+
+// Child returns the child of the node keyed by k or nil if no such child exists.
+func (n *TreeNode) Child(k term.Value) *TreeNode {
+	if n == nil {
+		return nil
+	}
+	switch k.(type) {
+	case term.String, term.Var:
+		return n.Children[k]
+	}
+	return nil
+}
+
+// Lookup returns the node reached by following ref from n or nil if ref does
+// not refer to a node in the tree.
+func (n *TreeNode) Lookup(ref term.Ref) *TreeNode {
+	node := n
+	for _, x := range ref {
+		if node = node.Child(x.Value); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// Find returns the deepest node reached by following ref from n and the
+// operands of ref that remain once no further node is found.
+func (n *TreeNode) Find(ref term.Ref) (*TreeNode, term.Ref) {
+	node := n
+	for i, x := range ref {
+		child := node.Child(x.Value)
+		if child == nil {
+			return node, ref[i:]
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// Sorted returns the keys of the node's children in sorted order.
+func (n *TreeNode) Sorted() []term.Value {
+	keys := make([]term.Value, 0, len(n.Children))
+	for k := range n.Children {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Compare(keys[j]) < 0
+	})
+	return keys
+}
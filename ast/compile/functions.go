@@ -0,0 +1,63 @@
+package compile
+
+import (
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+// checkFunctionCalls ensures that functions are called with the number of
+// arguments they declare and that they are not referred to as values.
+func (c *Compiler) checkFunctionCalls() {
+	for _, name := range c.sorted {
+		c.Errors = append(c.Errors, c.checkCalls(c.Modules[name])...)
+	}
+}
+
+func (c *Compiler) checkCalls(x interface{}) ast.Errors {
+	var errs ast.Errors
+	operators := map[*term.Term]struct{}{}
+
+	// functions may be replaced by with modifiers without being called
+	ast.WalkExprs(x, func(expr *ast.Expr) {
+		for _, w := range expr.With {
+			operators[w.Target] = struct{}{}
+			operators[w.Value] = struct{}{}
+		}
+	})
+
+	ast.WalkTerms(x, func(t *term.Term) bool {
+		switch v := t.Value.(type) {
+		case term.Call:
+			operators[v[0]] = struct{}{}
+			ref, ok := v[0].Value.(term.Ref)
+			if !ok {
+				break
+			}
+			if fn := c.lookupFunction(ref); fn != nil {
+				if arity := len(fn.Values[0].Args); arity != len(v)-1 {
+					errs = append(errs, ast.NewError(t.Location, "function %v expects %d arguments, got %d", ref, arity, len(v)-1))
+				}
+			}
+		case term.Ref:
+			if _, ok := operators[t]; ok {
+				break
+			}
+			if fn := c.lookupFunction(v); fn != nil {
+				errs = append(errs, ast.NewError(t.Location, "function %v must be called", v))
+			}
+		}
+		return false
+	})
+
+	return errs
+}
+
+// lookupFunction returns the rule tree node of the function ref refers to or
+// nil if ref does not refer to a function.
+func (c *Compiler) lookupFunction(ref term.Ref) *TreeNode {
+	node := c.RuleTree.Lookup(ref)
+	if node == nil || len(node.Values) == 0 || !node.Values[0].IsFunction() {
+		return nil
+	}
+	return node
+}
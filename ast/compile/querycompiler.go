@@ -10,6 +10,21 @@ type queryCompiler struct {
 	compiler *Compiler
 }
 
+// Compile checks the query for safety, function calls and with modifiers and
+// returns the compiled query.
 func (c queryCompiler) Compile(q ast.Body) (ast.Body, error) {
-	return nil, nil
+	if errs := c.compiler.checkCalls(q); len(errs) > 0 {
+		return nil, errs
+	}
+
+	if errs := c.compiler.checkWith(q); len(errs) > 0 {
+		return nil, errs
+	}
+
+	s := &safetyChecker{compiler: c.compiler}
+	s.checkBody(newVarSet(), q)
+	if len(s.errs) > 0 {
+		return nil, s.errs
+	}
+	return q, nil
 }
@@ -0,0 +1,151 @@
+package compile
+
+import (
+	"strings"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+// checkRecursion ensures that no rule or function refers to itself, either
+// directly or through the rules it refers to, e.g.
+//
+//	a := b
+//	b := a
+//
+// Evaluating such rules would never finish.
+func (c *Compiler) checkRecursion() {
+	r := &recursionChecker{
+		compiler: c,
+		paths:    map[*TreeNode]term.Ref{},
+		state:    map[*TreeNode]int{},
+	}
+	r.collect(c.RuleTree, term.Ref{})
+	for _, node := range r.nodes {
+		r.visit(node, nil)
+	}
+}
+
+// states of the nodes visited by the recursion checker
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+type recursionChecker struct {
+	compiler *Compiler
+	nodes    []*TreeNode            // nodes defining rules in tree order
+	paths    map[*TreeNode]term.Ref // path of each node defining rules
+	state    map[*TreeNode]int
+}
+
+// collect records the nodes of the tree below node that define rules.
+func (r *recursionChecker) collect(node *TreeNode, path term.Ref) {
+	if len(node.Values) > 0 {
+		r.nodes = append(r.nodes, node)
+		r.paths[node] = path
+	}
+	for _, k := range node.Sorted() {
+		r.collect(node.Children[k], append(append(term.Ref{}, path...), term.NewTerm(k)))
+	}
+}
+
+// visit reports a cycle if node is reached again while its dependencies are
+// visited. stack holds the nodes being visited that lead to node.
+func (r *recursionChecker) visit(node *TreeNode, stack []*TreeNode) {
+	switch r.state[node] {
+	case visited:
+		return
+	case visiting:
+		for i, x := range stack {
+			if x == node {
+				r.report(append(stack[i:], node))
+			}
+		}
+		return
+	}
+
+	r.state[node] = visiting
+	stack = append(stack, node)
+	for _, dep := range r.dependencies(node) {
+		r.visit(dep, stack)
+	}
+	r.state[node] = visited
+}
+
+func (r *recursionChecker) report(cycle []*TreeNode) {
+	names := make([]string, len(cycle))
+	for i, node := range cycle {
+		names[i] = r.paths[node].String()
+	}
+	first := cycle[0].Values[0]
+	kind := "rule"
+	if first.IsFunction() {
+		kind = "function"
+	}
+	r.compiler.errorf(first.Location, "%v %v is recursive: %v", kind, names[0], strings.Join(names, " -> "))
+}
+
+// dependencies returns the nodes defining the rules that the rules at node
+// refer to. The targets of with modifiers are replaced rather than evaluated
+// so they are not dependencies.
+func (r *recursionChecker) dependencies(node *TreeNode) []*TreeNode {
+	var deps []*TreeNode
+	seen := map[*TreeNode]bool{}
+	targets := map[*term.Term]bool{}
+
+	for _, rule := range node.Values {
+		ast.WalkExprs(rule, func(expr *ast.Expr) {
+			for _, w := range expr.With {
+				targets[w.Target] = true
+			}
+		})
+		ast.WalkTerms(rule, func(t *term.Term) bool {
+			ref, ok := t.Value.(term.Ref)
+			if !ok || targets[t] || !ref[0].Value.Equal(ast.RootDocument.Value) {
+				return false
+			}
+			for _, dep := range r.referred(ref) {
+				if !seen[dep] {
+					seen[dep] = true
+					deps = append(deps, dep)
+				}
+			}
+			return false
+		})
+	}
+
+	return deps
+}
+
+// referred returns the nodes defining the rules that ref may refer to.
+// Operands that are not strings may refer to any rule below the operands
+// preceding them.
+func (r *recursionChecker) referred(ref term.Ref) []*TreeNode {
+	node := r.compiler.RuleTree
+	for i, x := range ref {
+		if _, ok := x.Value.(term.String); !ok && i > 0 {
+			return r.below(ref[:i])
+		}
+		if node = node.Child(x.Value); node == nil {
+			return nil
+		}
+		if len(node.Values) > 0 {
+			return []*TreeNode{node}
+		}
+	}
+	// packages are not values
+	return nil
+}
+
+// below returns the nodes defining rules whose path begins with prefix.
+func (r *recursionChecker) below(prefix term.Ref) []*TreeNode {
+	var nodes []*TreeNode
+	for _, node := range r.nodes {
+		if path := r.paths[node]; len(path) >= len(prefix) && term.TermSliceCompare(path[:len(prefix)], prefix) == 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
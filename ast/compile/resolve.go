@@ -0,0 +1,167 @@
+package compile
+
+import (
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+// resolveRefsInRule rewrites the references to globals in the rule's value and
+// body into fully qualified references. Variables declared inside of the body
+// shadow globals of the same name, as do the arguments of functions. Variables
+// declared inside of a comprehension body are only local to the comprehension.
+// Each rule of an else chain is resolved in its own scope.
+func resolveRefsInRule(globals map[term.Var]term.Ref, rule *ast.Rule) {
+	for ; rule != nil; rule = rule.Else {
+		locals := newVarSet(rule.Args.Vars()...)
+		resolveRefsInBody(globals, locals, rule.Body)
+		rule.Key = resolveRefsInTerm(globals, locals, rule.Key)
+		rule.Value = resolveRefsInTerm(globals, locals, rule.Value)
+	}
+}
+
+func resolveRefsInBody(globals map[term.Var]term.Ref, locals varSet, body ast.Body) {
+	for _, expr := range body {
+		resolveRefsInExpr(globals, locals, expr)
+	}
+}
+
+func resolveRefsInExpr(globals map[term.Var]term.Ref, locals varSet, expr *ast.Expr) {
+	for _, w := range expr.With {
+		w.Target = resolveRefsInTerm(globals, locals, w.Target)
+		w.Value = resolveRefsInTerm(globals, locals, w.Value)
+	}
+
+	if some, ok := expr.Terms.(*ast.SomeDecl); ok {
+		some.Collection = resolveRefsInTerm(globals, locals, some.Collection)
+		for _, v := range some.Vars() {
+			locals.Add(v)
+		}
+		return
+	}
+
+	if every, ok := expr.Terms.(*ast.Every); ok {
+		every.Collection = resolveRefsInTerm(globals, locals, every.Collection)
+		inner := locals.Copy()
+		for _, v := range every.Vars() {
+			inner.Add(v)
+		}
+		resolveRefsInBody(globals, inner, every.Body)
+		return
+	}
+
+	t, ok := expr.Terms.(*term.Term)
+	if !ok {
+		return
+	}
+
+	if expr.IsDeclare() {
+		call := t.Value.(term.Call)
+		call[2] = resolveRefsInTerm(globals, locals, call[2])
+		if v, ok := call[1].Value.(term.Var); ok {
+			locals.Add(v)
+		}
+		return
+	}
+
+	expr.Terms = resolveRefsInTerm(globals, locals, t)
+}
+
+func resolveRefsInTerm(globals map[term.Var]term.Ref, locals varSet, t *term.Term) *term.Term {
+	if t == nil {
+		return nil
+	}
+
+	switch v := t.Value.(type) {
+	case term.Var:
+		if global, ok := lookupGlobal(globals, locals, v); ok {
+			return term.RefTerm(global...).SetLoc(t.Location)
+		}
+	case term.Ref:
+		var ref term.Ref
+		if head, ok := v[0].Value.(term.Var); ok {
+			if global, ok := lookupGlobal(globals, locals, head); ok {
+				ref = append(ref, global...)
+			} else {
+				ref = append(ref, v[0])
+			}
+		} else {
+			ref = append(ref, resolveRefsInTerm(globals, locals, v[0]))
+		}
+		for _, x := range v[1:] {
+			ref = append(ref, resolveRefsInTerm(globals, locals, x))
+		}
+		return term.RefTerm(ref...).SetLoc(t.Location)
+	case term.Call:
+		call := term.Call{resolveOperator(globals, locals, v[0])}
+		for _, x := range v[1:] {
+			call = append(call, resolveRefsInTerm(globals, locals, x))
+		}
+		return term.CallTerm(call...).SetLoc(t.Location)
+	case term.Array:
+		arr := make(term.Array, len(v))
+		for i, x := range v {
+			arr[i] = resolveRefsInTerm(globals, locals, x)
+		}
+		return term.ArrayTerm(arr...).SetLoc(t.Location)
+	case term.Set:
+		set := make([]*term.Term, len(v))
+		for i, x := range v {
+			set[i] = resolveRefsInTerm(globals, locals, x)
+		}
+		return term.SetTerm(set...).SetLoc(t.Location)
+	case term.Object:
+		pairs := make([][2]*term.Term, len(v))
+		for i, pair := range v {
+			pairs[i] = term.Item(resolveRefsInTerm(globals, locals, pair[0]), resolveRefsInTerm(globals, locals, pair[1]))
+		}
+		return term.ObjectTerm(pairs...).SetLoc(t.Location)
+	case *ast.ArrayComprehension:
+		locals = locals.Copy()
+		resolveRefsInBody(globals, locals, v.Body)
+		head := resolveRefsInTerm(globals, locals, v.Term)
+		return ast.ArrayComprehensionTerm(head, v.Body).SetLoc(t.Location)
+	case *ast.SetComprehension:
+		locals = locals.Copy()
+		resolveRefsInBody(globals, locals, v.Body)
+		head := resolveRefsInTerm(globals, locals, v.Term)
+		return ast.SetComprehensionTerm(head, v.Body).SetLoc(t.Location)
+	case *ast.ObjectComprehension:
+		locals = locals.Copy()
+		resolveRefsInBody(globals, locals, v.Body)
+		key := resolveRefsInTerm(globals, locals, v.Key)
+		value := resolveRefsInTerm(globals, locals, v.Value)
+		return ast.ObjectComprehensionTerm(key, value, v.Body).SetLoc(t.Location)
+	}
+
+	return t
+}
+
+// resolveOperator resolves the operator of a call to a function defined in the
+// package, e.g. is_admin(x) calls zego.pkg.is_admin. Other operators, such as
+// built-in functions, are left untouched.
+func resolveOperator(globals map[term.Var]term.Ref, locals varSet, op *term.Term) *term.Term {
+	ref, ok := op.Value.(term.Ref)
+	if !ok {
+		return op
+	}
+	head, ok := ref[0].Value.(term.Var)
+	if !ok {
+		return op
+	}
+	global, ok := lookupGlobal(globals, locals, head)
+	if !ok {
+		return op
+	}
+	return term.RefTerm(append(global, ref[1:]...)...).SetLoc(op.Location)
+}
+
+func lookupGlobal(globals map[term.Var]term.Ref, locals varSet, v term.Var) (term.Ref, bool) {
+	if locals.Contains(v) {
+		return nil, false
+	}
+	global, ok := globals[v]
+	if !ok {
+		return nil, false
+	}
+	return append(term.Ref{}, global...), true
+}
@@ -0,0 +1,54 @@
+package compile
+
+import "avidbound.com/zego/ast/term"
+
+// checkRuleConflicts ensures that the rules defined at the same path in the
+// rule tree are all of the same kind, that functions defined at the same path
+// have the same arity and that there is at most one default rule per path.
+// Rules cannot be defined at the path of a package either, e.g. rule b in
+// package a conflicts with package a.b.
+func (c *Compiler) checkRuleConflicts() {
+	c.checkNodeConflicts(c.RuleTree)
+}
+
+func (c *Compiler) checkNodeConflicts(node *TreeNode) {
+	if len(node.Values) > 0 {
+		first := node.Values[0]
+		defaults := 0
+		for i, rule := range node.Values {
+			if rule.Default {
+				if defaults++; defaults > 1 {
+					c.errorf(rule.Location, "multiple default rules %v found", rule.Name)
+				}
+			}
+			if i == 0 {
+				continue
+			}
+			switch {
+			case rule.Kind() != first.Kind():
+				c.errorf(rule.Location, "%v %v conflicts with %v %v", rule.Kind(), rule.Name, first.Kind(), first.Name)
+			case len(rule.Args) != len(first.Args):
+				c.errorf(rule.Location, "function %v has conflicting arity", rule.Name)
+			}
+		}
+		if len(node.Children) > 0 {
+			c.errorf(first.Location, "%v %v conflicts with package", first.Kind(), first.Name)
+		}
+	}
+	for _, k := range node.Sorted() {
+		c.checkNodeConflicts(node.Children[k])
+	}
+}
+
+// checkDefaultRules ensures that the values of default rules are constants,
+// they are used when no other rule produces a value and cannot depend on the
+// rules or input they are the fallback for.
+func (c *Compiler) checkDefaultRules() {
+	for _, name := range c.sorted {
+		for _, rule := range c.Modules[name].Rules {
+			if rule.Default && (rule.Value == nil || !term.IsGround(rule.Value.Value)) {
+				c.errorf(rule.Location, "default rule %v value must be a ground constant", rule.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package compile
+
+import (
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+type varSet map[term.Var]struct{}
+
+func newVarSet(vs ...term.Var) varSet {
+	s := varSet{}
+	for _, v := range vs {
+		s.Add(v)
+	}
+	return s
+}
+
+func (s varSet) Add(v term.Var) {
+	s[v] = struct{}{}
+}
+
+func (s varSet) Contains(v term.Var) bool {
+	_, ok := s[v]
+	return ok
+}
+
+func (s varSet) Copy() varSet {
+	cpy := varSet{}
+	for v := range s {
+		cpy.Add(v)
+	}
+	return cpy
+}
+
+// checkSafetyRules ensures that every variable read by a rule is bound before
+// it is read. Expressions are evaluated in order, so a variable becomes bound
+// by being declared (x := 1) or by appearing as an operand of a reference
+// (input.items[x]). Function arguments are bound by the caller. Comprehensions are checked in their own scope, variables
+// bound inside of their body are not visible outside of it.
+func (c *Compiler) checkSafetyRules() {
+	for _, name := range c.sorted {
+		for _, rule := range c.Modules[name].Rules {
+			for ; rule != nil; rule = rule.Else {
+				s := &safetyChecker{compiler: c}
+				safe := s.checkBody(newVarSet(rule.Args.Vars()...), rule.Body)
+				s.checkTerm(safe, rule.Key)
+				s.checkTerm(safe, rule.Value)
+				c.Errors = append(c.Errors, s.errs...)
+			}
+		}
+	}
+}
+
+type safetyChecker struct {
+	compiler *Compiler
+	errs     ast.Errors
+}
+
+// checkBody checks each expression in the body and returns the variables that
+// are bound once the body has been evaluated.
+func (s *safetyChecker) checkBody(safe varSet, body ast.Body) varSet {
+	safe = safe.Copy()
+	for _, expr := range body {
+		s.checkExpr(safe, expr)
+	}
+	return safe
+}
+
+func (s *safetyChecker) checkExpr(safe varSet, expr *ast.Expr) {
+	for _, w := range expr.With {
+		if !s.isFunctionName(safe, w.Value) {
+			s.checkTerm(safe, w.Value)
+		}
+	}
+
+	if some, ok := expr.Terms.(*ast.SomeDecl); ok {
+		s.checkTerm(safe, some.Collection)
+		for _, x := range []*term.Term{some.Key, some.Value} {
+			if x != nil && !x.Value.Equal(ast.Wildcard.Value) {
+				s.checkDeclare(safe, x)
+			}
+		}
+		return
+	}
+
+	if every, ok := expr.Terms.(*ast.Every); ok {
+		// the body of every is checked in its own scope, variables bound
+		// inside of it are not visible after the expression
+		s.checkTerm(safe, every.Collection)
+		inner := safe.Copy()
+		for _, x := range []*term.Term{every.Key, every.Value} {
+			if x != nil && !x.Value.Equal(ast.Wildcard.Value) {
+				s.checkDeclare(inner, x)
+			}
+		}
+		s.checkBody(inner, every.Body)
+		return
+	}
+
+	t, ok := expr.Terms.(*term.Term)
+	if !ok {
+		return
+	}
+
+	if expr.IsDeclare() {
+		call := t.Value.(term.Call)
+		s.checkTerm(safe, call[2])
+		s.checkDeclare(safe, call[1])
+		return
+	}
+
+	if expr.Negated {
+		// negated expressions cannot bind variables, every variable must be
+		// bound before the expression is evaluated
+		inner := safe.Copy()
+		s.checkTerm(inner, t)
+		ast.WalkVars(t, func(v term.Var) {
+			if inner.Contains(v) && !safe.Contains(v) {
+				s.errorf(expr.Location, "var %v is unsafe", v)
+				safe.Add(v) // report once
+			}
+		})
+		return
+	}
+
+	if expr.IsUnify() {
+		call := t.Value.(term.Call)
+		s.checkUnify(safe, call[1], call[2])
+		return
+	}
+
+	s.checkTerm(safe, t)
+}
+
+// checkUnify checks the unification of a and b. Arrays and objects of the same
+// shape are unified element by element, otherwise the variables of one side are
+// bound by the other side once all of its variables are safe.
+func (s *safetyChecker) checkUnify(safe varSet, a, b *term.Term) {
+	switch x := a.Value.(type) {
+	case term.Array:
+		if y, ok := b.Value.(term.Array); ok && len(x) == len(y) {
+			for i := range x {
+				s.checkUnify(safe, x[i], y[i])
+			}
+			return
+		}
+	case term.Object:
+		if y, ok := b.Value.(term.Object); ok && len(x) == len(y) {
+			if pairs, ok := pairObjects(x, y); ok {
+				for _, pair := range pairs {
+					s.checkUnify(safe, pair[0], pair[1])
+				}
+				return
+			}
+		}
+	}
+
+	switch {
+	case s.isSafe(safe, b):
+		s.checkTerm(safe, b)
+		s.checkOutput(safe, a)
+	case s.isSafe(safe, a):
+		s.checkTerm(safe, a)
+		s.checkOutput(safe, b)
+	default:
+		s.checkTerm(safe, a)
+		s.checkTerm(safe, b)
+	}
+}
+
+// checkOutput checks a term whose variables are bound by unification. Variables
+// in arrays and object values are outputs, everything else must be safe.
+func (s *safetyChecker) checkOutput(safe varSet, t *term.Term) {
+	switch v := t.Value.(type) {
+	case term.Var:
+		if !ast.IsWildcard(v) && !ast.IsRootDocument(v) {
+			safe.Add(v)
+		}
+	case term.Array:
+		for _, x := range v {
+			s.checkOutput(safe, x)
+		}
+	case term.Object:
+		for _, pair := range v {
+			s.checkTerm(safe, pair[0])
+			s.checkOutput(safe, pair[1])
+		}
+	default:
+		s.checkTerm(safe, t)
+	}
+}
+
+// isSafe returns true if every variable read by t is safe.
+func (s *safetyChecker) isSafe(safe varSet, t *term.Term) bool {
+	tmp := &safetyChecker{compiler: s.compiler}
+	tmp.checkTerm(safe.Copy(), t)
+	return len(tmp.errs) == 0
+}
+
+// pairObjects returns the values of a and b that share a key. The keys of a
+// must be ground and each of them must be a key of b.
+func pairObjects(a, b term.Object) ([][2]*term.Term, bool) {
+	pairs := make([][2]*term.Term, 0, len(a))
+	for _, pair := range a {
+		if !term.IsGround(pair[0].Value) {
+			return nil, false
+		}
+		x := b.Get(pair[0])
+		if x == nil {
+			return nil, false
+		}
+		pairs = append(pairs, [2]*term.Term{pair[1], x})
+	}
+	return pairs, true
+}
+
+// isFunctionName returns true if t names a built-in function, e.g.
+// time.now_ns, or a user function rather than referring to a value.
+func (s *safetyChecker) isFunctionName(safe varSet, t *term.Term) bool {
+	ref, ok := t.Value.(term.Ref)
+	if !ok {
+		ref = term.Ref{t}
+	}
+	head, ok := ref[0].Value.(term.Var)
+	if !ok || safe.Contains(head) {
+		return false
+	}
+	if ast.IsRootDocument(head) {
+		node, rest := s.compiler.RuleTree.Find(ref)
+		return len(rest) == 0 && len(node.Values) > 0 && node.Values[0].IsFunction()
+	}
+	return ast.IsBuiltin(t.String())
+}
+
+// checkTerm checks the variables read by t in evaluation order. Variables that
+// are operands of references are outputs and are added to the safe set.
+func (s *safetyChecker) checkTerm(safe varSet, t *term.Term) {
+	if t == nil {
+		return
+	}
+
+	switch v := t.Value.(type) {
+	case term.Var:
+		s.checkRead(safe, v, t.Location)
+	case term.Ref:
+		if head, ok := v[0].Value.(term.Var); ok {
+			s.checkRead(safe, head, v[0].Location)
+		} else {
+			s.checkTerm(safe, v[0])
+		}
+		for _, x := range v[1:] {
+			if o, ok := x.Value.(term.Var); ok {
+				if !ast.IsWildcard(o) {
+					safe.Add(o)
+				}
+				continue
+			}
+			s.checkTerm(safe, x)
+		}
+	case term.Call:
+		for _, x := range v[1:] {
+			s.checkTerm(safe, x)
+		}
+	case term.Array:
+		for _, x := range v {
+			s.checkTerm(safe, x)
+		}
+	case term.Set:
+		for _, x := range v {
+			s.checkTerm(safe, x)
+		}
+	case term.Object:
+		for _, pair := range v {
+			s.checkTerm(safe, pair[0])
+			s.checkTerm(safe, pair[1])
+		}
+	case *ast.ArrayComprehension:
+		s.checkTerm(s.checkBody(safe, v.Body), v.Term)
+	case *ast.SetComprehension:
+		s.checkTerm(s.checkBody(safe, v.Body), v.Term)
+	case *ast.ObjectComprehension:
+		inner := s.checkBody(safe, v.Body)
+		s.checkTerm(inner, v.Key)
+		s.checkTerm(inner, v.Value)
+	}
+}
+
+// checkDeclare adds the variable declared by t to the safe set.
+func (s *safetyChecker) checkDeclare(safe varSet, t *term.Term) {
+	v, ok := t.Value.(term.Var)
+	switch {
+	case !ok || ast.IsWildcard(v) || ast.IsRootDocument(v):
+		s.errorf(t.Location, "cannot declare %v", t)
+	case safe.Contains(v):
+		s.errorf(t.Location, "var %v declared above", v)
+	default:
+		safe.Add(v)
+	}
+}
+
+// checkRead reports v if it is not bound. An unsafe variable is only reported
+// at its first read, later reads in the same scope, such as the term of a
+// comprehension, treat it as bound.
+func (s *safetyChecker) checkRead(safe varSet, v term.Var, loc *term.Location) {
+	if ast.IsRootDocument(v) || safe.Contains(v) {
+		return
+	}
+	s.errorf(loc, "var %v is unsafe", v)
+	safe.Add(v)
+}
+
+func (s *safetyChecker) errorf(loc *term.Location, f string, a ...interface{}) {
+	s.errs = append(s.errs, ast.NewError(loc, f, a...))
+}
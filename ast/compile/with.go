@@ -0,0 +1,50 @@
+package compile
+
+import (
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+// checkWithModifiers ensures that the targets of with modifiers refer to the
+// input document, to a document produced by rules or to a function.
+func (c *Compiler) checkWithModifiers() {
+	for _, name := range c.sorted {
+		c.Errors = append(c.Errors, c.checkWith(c.Modules[name])...)
+	}
+}
+
+func (c *Compiler) checkWith(x interface{}) ast.Errors {
+	var errs ast.Errors
+
+	ast.WalkExprs(x, func(expr *ast.Expr) {
+		for _, w := range expr.With {
+			if !c.isWithTarget(w.Target) {
+				errs = append(errs, ast.NewError(w.Location, "with target %v must refer to input, a document or a function", w.Target))
+			}
+		}
+	})
+
+	return errs
+}
+
+// isWithTarget returns true if t can be replaced by a with modifier: the input
+// document, a package or document produced by rules, either as a whole or in
+// part, a user function or a built-in function. Documents below zego that no
+// rule produces cannot be replaced as there are no base documents.
+func (c *Compiler) isWithTarget(t *term.Term) bool {
+	ref, ok := t.Value.(term.Ref)
+	if !ok {
+		ref = term.Ref{t}
+	}
+	switch {
+	case ref[0].Value.Equal(ast.InputRootDocument.Value):
+		return true
+	case ref[0].Value.Equal(ast.RootDocument.Value):
+		node, rest := c.RuleTree.Find(ref)
+		if len(rest) == 0 {
+			return true
+		}
+		return len(node.Values) > 0 && !node.Values[0].IsFunction()
+	}
+	return ast.IsBuiltin(t.String())
+}
@@ -0,0 +1,166 @@
+package ast
+
+import (
+	"strings"
+
+	"avidbound.com/zego/ast/term"
+)
+
+type (
+	// ArrayComprehension represents an array comprehension as defined in the
+	// language, e.g. [x | x := input.items[_]; x.enabled].
+	ArrayComprehension struct {
+		Term *term.Term `json:"term"`
+		Body Body       `json:"body"`
+	}
+
+	// SetComprehension represents a set comprehension as defined in the
+	// language, e.g. {x | x := input.items[_]}.
+	SetComprehension struct {
+		Term *term.Term `json:"term"`
+		Body Body       `json:"body"`
+	}
+
+	// ObjectComprehension represents an object comprehension as defined in the
+	// language, e.g. {k: v | v := input.items[k]}.
+	ObjectComprehension struct {
+		Key   *term.Term `json:"key"`
+		Value *term.Term `json:"value"`
+		Body  Body       `json:"body"`
+	}
+)
+
+// ArrayComprehensionTerm creates a new Term with an ArrayComprehension value.
+func ArrayComprehensionTerm(t *term.Term, body Body) *term.Term {
+	return term.NewTerm(&ArrayComprehension{Term: t, Body: body})
+}
+
+// SetComprehensionTerm creates a new Term with a SetComprehension value.
+func SetComprehensionTerm(t *term.Term, body Body) *term.Term {
+	return term.NewTerm(&SetComprehension{Term: t, Body: body})
+}
+
+// ObjectComprehensionTerm creates a new Term with an ObjectComprehension value.
+func ObjectComprehensionTerm(key, value *term.Term, body Body) *term.Term {
+	return term.NewTerm(&ObjectComprehension{Key: key, Value: value, Body: body})
+}
+
+// Equal returns true if the other Value is an equal ArrayComprehension.
+func (ac *ArrayComprehension) Equal(other term.Value) bool {
+	return ac.Compare(other) == 0
+}
+
+// Compare compares ac to other, return <0, 0, or >0 if it is less than, equal to,
+// or greater than other.
+func (ac *ArrayComprehension) Compare(other term.Value) int {
+	if sort := compareSortOrder(ac, other); sort != 0 {
+		return sort
+	}
+
+	o := other.(*ArrayComprehension)
+	if cmp := ac.Term.Value.Compare(o.Term.Value); cmp != 0 {
+		return cmp
+	}
+	return ac.Body.Compare(o.Body)
+}
+
+func (ac *ArrayComprehension) String() string {
+	return "[" + ac.Term.String() + " | " + comprehensionBody(ac.Body) + "]"
+}
+
+// Hash returns the hash code for the Value.
+func (ac *ArrayComprehension) Hash() int {
+	return ac.Term.Value.Hash() + ac.Body.Hash()
+}
+
+func (ac *ArrayComprehension) SortOrder() int {
+	return 11
+}
+
+// Equal returns true if the other Value is an equal SetComprehension.
+func (sc *SetComprehension) Equal(other term.Value) bool {
+	return sc.Compare(other) == 0
+}
+
+// Compare compares sc to other, return <0, 0, or >0 if it is less than, equal to,
+// or greater than other.
+func (sc *SetComprehension) Compare(other term.Value) int {
+	if sort := compareSortOrder(sc, other); sort != 0 {
+		return sort
+	}
+
+	o := other.(*SetComprehension)
+	if cmp := sc.Term.Value.Compare(o.Term.Value); cmp != 0 {
+		return cmp
+	}
+	return sc.Body.Compare(o.Body)
+}
+
+func (sc *SetComprehension) String() string {
+	return "{" + sc.Term.String() + " | " + comprehensionBody(sc.Body) + "}"
+}
+
+// Hash returns the hash code for the Value.
+func (sc *SetComprehension) Hash() int {
+	return sc.Term.Value.Hash() + sc.Body.Hash()
+}
+
+func (sc *SetComprehension) SortOrder() int {
+	return 12
+}
+
+// Equal returns true if the other Value is an equal ObjectComprehension.
+func (oc *ObjectComprehension) Equal(other term.Value) bool {
+	return oc.Compare(other) == 0
+}
+
+// Compare compares oc to other, return <0, 0, or >0 if it is less than, equal to,
+// or greater than other.
+func (oc *ObjectComprehension) Compare(other term.Value) int {
+	if sort := compareSortOrder(oc, other); sort != 0 {
+		return sort
+	}
+
+	o := other.(*ObjectComprehension)
+	if cmp := oc.Key.Value.Compare(o.Key.Value); cmp != 0 {
+		return cmp
+	}
+	if cmp := oc.Value.Value.Compare(o.Value.Value); cmp != 0 {
+		return cmp
+	}
+	return oc.Body.Compare(o.Body)
+}
+
+func (oc *ObjectComprehension) String() string {
+	return "{" + oc.Key.String() + ": " + oc.Value.String() + " | " + comprehensionBody(oc.Body) + "}"
+}
+
+// Hash returns the hash code for the Value.
+func (oc *ObjectComprehension) Hash() int {
+	return oc.Key.Value.Hash() + oc.Value.Value.Hash() + oc.Body.Hash()
+}
+
+func (oc *ObjectComprehension) SortOrder() int {
+	return 13
+}
+
+func comprehensionBody(body Body) string {
+	buf := make([]string, len(body))
+	for i, expr := range body {
+		buf[i] = expr.String()
+	}
+	return strings.Join(buf, "; ")
+}
+
+func compareSortOrder(a, b term.Value) int {
+	ao := a.SortOrder()
+	bo := b.SortOrder()
+
+	if ao < bo {
+		return -1
+	} else if bo < ao {
+		return 1
+	}
+
+	return 0
+}
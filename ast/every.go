@@ -0,0 +1,50 @@
+package ast
+
+import "avidbound.com/zego/ast/term"
+
+// Every represents universal quantification over a collection as defined in
+// the language, e.g. every x in input.containers { x.image != "" }. The body
+// is evaluated in its own scope for each element of the collection.
+type Every struct {
+	Key        *term.Term `json:"key,omitempty"`
+	Value      *term.Term `json:"value"`
+	Collection *term.Term `json:"collection"`
+	Body       Body       `json:"body"`
+}
+
+// Compare returns an integer indicating whether every is less than, equal to,
+// or greater than other.
+func (every *Every) Compare(other *Every) int {
+	if cmp := every.Key.Compare(other.Key); cmp != 0 {
+		return cmp
+	}
+	if cmp := every.Value.Compare(other.Value); cmp != 0 {
+		return cmp
+	}
+	if cmp := every.Collection.Compare(other.Collection); cmp != 0 {
+		return cmp
+	}
+	return every.Body.Compare(other.Body)
+}
+
+// Hash returns the hash code of the expression.
+func (every *Every) Hash() int {
+	hash := every.Value.Value.Hash() + every.Collection.Value.Hash() + every.Body.Hash()
+	if every.Key != nil {
+		hash += every.Key.Value.Hash()
+	}
+	return hash
+}
+
+func (every *Every) String() string {
+	head := "every " + every.Value.String()
+	if every.Key != nil {
+		head = "every " + every.Key.String() + ", " + every.Value.String()
+	}
+	return head + " in " + every.Collection.String() + " { " + comprehensionBody(every.Body) + " }"
+}
+
+// Vars returns the variables declared for each element of the collection.
+func (every *Every) Vars() []term.Var {
+	return (&SomeDecl{Key: every.Key, Value: every.Value}).Vars()
+}
@@ -15,6 +15,7 @@ type Item struct {
 	Token tokens.Token // Type, such as itemNumber.
 	Value string       // Value, such as "23.2".
 	Pos   Pos          // The starting position, in bytes, of this item in the input string.
+	End   Pos          // The position following the last byte of this item.
 }
 
 // stateFn represents the state of the scanner as a function that returns the next state.
@@ -59,6 +60,19 @@ func (b *blockDepth) pop() tokens.Token {
 	return tokens.EOF
 }
 
+// close pops the blocks up to and including the innermost block opened by t,
+// blocks left open inside of it are closed as well. It returns false if no
+// block was opened by t.
+func (b *blockDepth) close(t tokens.Token) bool {
+	for i := len(b.tokens) - 1; i >= 0; i-- {
+		if b.tokens[i] == t {
+			b.tokens = b.tokens[:i]
+			return true
+		}
+	}
+	return false
+}
+
 // lex creates a new scanner for the input string.
 func Lex(name, input string) []Item {
 	l := &lexer{
@@ -116,27 +130,24 @@ func (l *lexer) backup() {
 	if l.width == 1 && l.input[l.pos.Index] == '\n' {
 		l.pos.Line--
 		// Correct column count.
-		for i := l.pos.Index - 1; i >= 0; i-- {
-			if i == 0 || l.input[i] == '\n' {
-				l.pos.Column = l.pos.Index - i
-				break
-			}
-		}
+		l.pos.Column = l.column()
 	} else {
 		l.pos.Column -= l.width
 	}
 }
 
+// column returns the column of the current position, counted from the last
+// newline before it or from the start of the input.
+func (l *lexer) column() int {
+	i := l.pos.Index - 1
+	for i >= 0 && l.input[i] != '\n' {
+		i--
+	}
+	return l.pos.Index - i
+}
+
 // ignore skips over the pending input before this point.
 func (l *lexer) ignore() {
-	l.pos.Line += strings.Count(l.input[l.start.Index:l.pos.Index], "\n")
-	// correct column count
-	for i := l.pos.Index - 1; i >= 0; i-- {
-		if i == 0 || l.input[i] == '\n' {
-			l.pos.Column = l.pos.Index - i
-			break
-		}
-	}
 	l.start = l.pos
 }
 
@@ -162,7 +173,8 @@ func lexScan(l *lexer) stateFn {
 	switch r := l.next(); {
 	case r == eof:
 		if l.blockDepth.pop() != tokens.EOF {
-			return l.errorf("unexpected EOF")
+			l.blockDepth = blockDepth{}
+			l.errorf("unexpected EOF")
 		}
 		l.emit(tokens.EOF)
 		return nil
@@ -181,10 +193,12 @@ func lexScan(l *lexer) stateFn {
 			l.emit(tokens.Assign)
 		}
 	case r == '!':
-		if l.next() != '=' {
-			return l.errorf("illegal ! character")
+		if l.peek() == '=' {
+			l.next()
+			l.emit(tokens.NEqual)
+		} else {
+			l.emit(tokens.Bang)
 		}
-		l.emit(tokens.NEqual)
 	case r == '<':
 		if l.peek() == '=' {
 			l.next()
@@ -200,12 +214,14 @@ func lexScan(l *lexer) stateFn {
 			l.emit(tokens.GT)
 		}
 	case r == ':':
-		if l.next() != '=' {
-			return l.errorf("expected :=")
+		if l.peek() == '=' {
+			l.next()
+			l.emit(tokens.Declare)
+		} else {
+			l.emit(tokens.Colon)
 		}
-		l.emit(tokens.Declare)
 	case r == '&':
-		l.emit(tokens.Add)
+		l.emit(tokens.And)
 	case r == '|':
 		l.emit(tokens.Or)
 	case r == '/':
@@ -224,6 +240,8 @@ func lexScan(l *lexer) stateFn {
 		return lexRawQuote
 	case r == ',':
 		l.emit(tokens.Comma)
+	case r == ';':
+		l.emit(tokens.Semicolon)
 	case r == '.':
 		// special look-ahead for ".field" so we don't break l.backup().
 		if l.pos.Index < len(l.input) {
@@ -243,7 +261,7 @@ func lexScan(l *lexer) stateFn {
 		l.emit(tokens.LBracket)
 		l.blockDepth.push(tokens.LBracket)
 	case r == ']':
-		if l.blockDepth.pop() != tokens.LBracket {
+		if !l.blockDepth.close(tokens.LBracket) {
 			return l.errorf("unexpected right bracket %#U", r)
 		}
 		l.emit(tokens.RBracket)
@@ -251,7 +269,7 @@ func lexScan(l *lexer) stateFn {
 		l.emit(tokens.LParenthesis)
 		l.blockDepth.push(tokens.LParenthesis)
 	case r == ')':
-		if l.blockDepth.pop() != tokens.LParenthesis {
+		if !l.blockDepth.close(tokens.LParenthesis) {
 			return l.errorf("unexpected right paren %#U", r)
 		}
 		l.emit(tokens.RParenthesis)
@@ -259,7 +277,7 @@ func lexScan(l *lexer) stateFn {
 		l.emit(tokens.LBrace)
 		l.blockDepth.push(tokens.LBrace)
 	case r == '}':
-		if l.blockDepth.pop() != tokens.LBrace {
+		if !l.blockDepth.close(tokens.LBrace) {
 			return l.errorf("unexpected right brace %#U", r)
 		}
 		l.emit(tokens.RBrace)
@@ -309,16 +327,17 @@ func lexWhitespace(l *lexer) stateFn {
 	return lexScan
 }
 
-// lexComment scans a comment. The left comment marker is known to be present.
+// lexComment scans a comment up to the end of the line. The left comment
+// marker is known to be present.
 func lexComment(l *lexer) stateFn {
-	var r rune
 	for {
-		r = l.next()
+		r := l.peek()
 		if isEndOfLine(r) || r == eof {
 			break
 		}
+		l.next()
 	}
-	l.ignore()
+	l.emit(tokens.Comment)
 	return lexScan
 }
 
@@ -333,6 +352,7 @@ Loop:
 			}
 			fallthrough
 		case eof, '\n':
+			l.backup() // the end of line is not part of the string
 			return l.errorf("unterminated quoted string")
 		case '"':
 			break Loop
@@ -461,7 +481,7 @@ func (l *lexer) atTerminator() bool {
 		return true
 	}
 	switch r {
-	case eof, '+', '-', '/', '%', '*', '.', ',', '|', ':', ']', '[', ')', '(':
+	case eof, '+', '-', '/', '%', '*', '.', ',', ';', '|', '&', ':', ']', '[', ')', '(', '}', '{':
 		return true
 	}
 	// Does r start the delimiter? This can be ambiguous (with delim=="//", $x/2 will
@@ -479,23 +499,38 @@ func (l *lexer) emit(t tokens.Token) {
 		Token: t,
 		Value: l.input[l.start.Index:l.pos.Index],
 		Pos:   l.start,
+		End:   l.pos,
 	}
 
 	l.items = append(l.items, item)
 	l.start = l.pos
 }
 
-// errorf returns an error token and terminates the scan by passing
-// back a nil pointer that will be the next state, terminating l.nextItem.
+// errorf emits an error token and returns the state that skips the rest of
+// the invalid input, so that scanning continues after the error.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 	item := Item{
 		Token: tokens.Illegal,
 		Value: fmt.Sprintf(format, args...),
 		Pos:   l.start,
+		End:   l.pos,
 	}
 
 	l.items = append(l.items, item)
-	return nil
+	return lexSkip
+}
+
+// lexSkip skips the input up to the next space, end of line or end of input.
+func lexSkip(l *lexer) stateFn {
+	for {
+		r := l.peek()
+		if r == eof || isSpace(r) || isEndOfLine(r) {
+			break
+		}
+		l.next()
+	}
+	l.start = l.pos
+	return lexScan
 }
 
 // isSpace reports whether r is a space character.
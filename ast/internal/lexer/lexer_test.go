@@ -76,3 +76,289 @@ func TestLexExpression(t *testing.T) {
 		}
 	}
 }
+
+func TestLexSetOperators(t *testing.T) {
+	// Arrange
+	input := `a&b | c`
+
+	tokens := []tokens.Token{
+		tokens.Identifier,
+		tokens.And,
+		tokens.Identifier,
+		tokens.Whitespace,
+		tokens.Or,
+		tokens.Whitespace,
+		tokens.Identifier,
+		tokens.EOF,
+	}
+
+	// Act
+	items := Lex("test", input)
+
+	// Assert
+	if len(tokens) != len(items) {
+		t.Errorf("want tokens %d but got %d", len(tokens), len(items))
+	}
+
+	for i, item := range items {
+		if i < len(tokens) && tokens[i] != item.Token {
+			t.Errorf("token %d: want token %s but got %s", i, tokens[i], item.Token)
+		}
+	}
+}
+
+func TestLexComprehension(t *testing.T) {
+	// Arrange
+	input := `[x | x := a[_]; x]`
+
+	tokens := []tokens.Token{
+		tokens.LBracket,
+		tokens.Identifier,
+		tokens.Whitespace,
+		tokens.Or,
+		tokens.Whitespace,
+		tokens.Identifier,
+		tokens.Whitespace,
+		tokens.Declare,
+		tokens.Whitespace,
+		tokens.Identifier,
+		tokens.LBracket,
+		tokens.Identifier,
+		tokens.RBracket,
+		tokens.Semicolon,
+		tokens.Whitespace,
+		tokens.Identifier,
+		tokens.RBracket,
+		tokens.EOF,
+	}
+
+	// Act
+	items := Lex("test", input)
+
+	// Assert
+	if len(tokens) != len(items) {
+		t.Errorf("want tokens %d but got %d", len(tokens), len(items))
+	}
+
+	for i, item := range items {
+		if i < len(tokens) && tokens[i] != item.Token {
+			t.Errorf("token %d: want token %s but got %s", i, tokens[i], item.Token)
+		}
+	}
+}
+
+func TestLexPrefixOperators(t *testing.T) {
+	// Arrange
+	input := `!a != -1`
+
+	tokens := []tokens.Token{
+		tokens.Bang,
+		tokens.Identifier,
+		tokens.Whitespace,
+		tokens.NEqual,
+		tokens.Whitespace,
+		tokens.Subtract,
+		tokens.Number,
+		tokens.EOF,
+	}
+
+	// Act
+	items := Lex("test", input)
+
+	// Assert
+	if len(tokens) != len(items) {
+		t.Errorf("want tokens %d but got %d", len(tokens), len(items))
+	}
+
+	for i, item := range items {
+		if i < len(tokens) && tokens[i] != item.Token {
+			t.Errorf("token %d: want token %s but got %s", i, tokens[i], item.Token)
+		}
+	}
+}
+
+func TestLexErrorRecovery(t *testing.T) {
+	// Arrange
+	input := "a $b ]\n[1)"
+
+	tokens := []tokens.Token{
+		tokens.Identifier,
+		tokens.Whitespace,
+		tokens.Illegal,
+		tokens.Whitespace,
+		tokens.Illegal,
+		tokens.EOL,
+		tokens.LBracket,
+		tokens.Number,
+		tokens.Illegal,
+		tokens.Illegal,
+		tokens.EOF,
+	}
+
+	// Act
+	items := Lex("test", input)
+
+	// Assert
+	if len(tokens) != len(items) {
+		t.Errorf("want tokens %d but got %d", len(tokens), len(items))
+	}
+
+	for i, item := range items {
+		if i < len(tokens) && tokens[i] != item.Token {
+			t.Errorf("token %d: want token %s but got %s", i, tokens[i], item.Token)
+		}
+	}
+}
+
+func TestLexObject(t *testing.T) {
+	// Arrange
+	input := `x := {"a": b}`
+
+	tokens := []tokens.Token{
+		tokens.Identifier,
+		tokens.Whitespace,
+		tokens.Declare,
+		tokens.Whitespace,
+		tokens.LBrace,
+		tokens.String,
+		tokens.Colon,
+		tokens.Whitespace,
+		tokens.Identifier,
+		tokens.RBrace,
+		tokens.EOF,
+	}
+
+	// Act
+	items := Lex("test", input)
+
+	// Assert
+	if len(tokens) != len(items) {
+		t.Errorf("want tokens %d but got %d", len(tokens), len(items))
+	}
+
+	for i, item := range items {
+		if i < len(tokens) && tokens[i] != item.Token {
+			t.Errorf("token %d: want token %s but got %s", i, tokens[i], item.Token)
+		}
+	}
+}
+
+func TestLexRawString(t *testing.T) {
+	// Arrange
+	input := "a := `x\n\ty`\nb"
+
+	expected := []struct {
+		token  tokens.Token
+		line   int
+		column int
+	}{
+		{tokens.Identifier, 1, 1},
+		{tokens.Whitespace, 1, 2},
+		{tokens.Declare, 1, 3},
+		{tokens.Whitespace, 1, 5},
+		{tokens.RawString, 1, 6},
+		{tokens.EOL, 2, 4},
+		{tokens.Identifier, 3, 1},
+		{tokens.EOF, 3, 2},
+	}
+
+	// Act
+	items := Lex("test", input)
+
+	// Assert
+	if len(expected) != len(items) {
+		t.Fatalf("want tokens %d but got %d", len(expected), len(items))
+	}
+
+	for i, item := range items {
+		if expected[i].token != item.Token {
+			t.Errorf("token %d: want token %s but got %s", i, expected[i].token, item.Token)
+		}
+		if expected[i].line != item.Pos.Line || expected[i].column != item.Pos.Column {
+			t.Errorf("token %d: want position %d:%d but got %d:%d", i, expected[i].line, expected[i].column, item.Pos.Line, item.Pos.Column)
+		}
+	}
+}
+
+func TestLexEnd(t *testing.T) {
+	// Arrange
+	input := "a.b\nc := `x\n\ty`"
+
+	expected := []struct {
+		token  tokens.Token
+		line   int
+		column int
+		index  int
+	}{
+		{tokens.Identifier, 1, 2, 1},
+		{tokens.Field, 1, 4, 3},
+		{tokens.EOL, 2, 1, 4},
+		{tokens.Identifier, 2, 2, 5},
+		{tokens.Whitespace, 2, 3, 6},
+		{tokens.Declare, 2, 5, 8},
+		{tokens.Whitespace, 2, 6, 9},
+		{tokens.RawString, 3, 4, 15},
+		{tokens.EOF, 3, 4, 15},
+	}
+
+	// Act
+	items := Lex("test", input)
+
+	// Assert
+	if len(expected) != len(items) {
+		t.Fatalf("want tokens %d but got %d", len(expected), len(items))
+	}
+
+	for i, item := range items {
+		if expected[i].token != item.Token {
+			t.Errorf("token %d: want token %s but got %s", i, expected[i].token, item.Token)
+		}
+		if expected[i].line != item.End.Line || expected[i].column != item.End.Column || expected[i].index != item.End.Index {
+			t.Errorf("token %d: want end %d:%d at %d but got %d:%d at %d", i, expected[i].line, expected[i].column, expected[i].index, item.End.Line, item.End.Column, item.End.Index)
+		}
+	}
+}
+
+func TestLexComment(t *testing.T) {
+	// Arrange
+	input := "# a\n\n# b\nc := 1 # d\ne"
+
+	expected := []struct {
+		token  tokens.Token
+		value  string
+		line   int
+		column int
+	}{
+		{tokens.Comment, "# a", 1, 1},
+		{tokens.EOL, "\n\n", 1, 4},
+		{tokens.Comment, "# b", 3, 1},
+		{tokens.EOL, "\n", 3, 4},
+		{tokens.Identifier, "c", 4, 1},
+		{tokens.Whitespace, " ", 4, 2},
+		{tokens.Declare, ":=", 4, 3},
+		{tokens.Whitespace, " ", 4, 5},
+		{tokens.Number, "1", 4, 6},
+		{tokens.Whitespace, " ", 4, 7},
+		{tokens.Comment, "# d", 4, 8},
+		{tokens.EOL, "\n", 4, 11},
+		{tokens.Identifier, "e", 5, 1},
+		{tokens.EOF, "", 5, 2},
+	}
+
+	// Act
+	items := Lex("test", input)
+
+	// Assert
+	if len(expected) != len(items) {
+		t.Fatalf("want tokens %d but got %d", len(expected), len(items))
+	}
+
+	for i, item := range items {
+		if expected[i].token != item.Token || expected[i].value != item.Value {
+			t.Errorf("token %d: want %s %q but got %s %q", i, expected[i].token, expected[i].value, item.Token, item.Value)
+		}
+		if expected[i].line != item.Pos.Line || expected[i].column != item.Pos.Column {
+			t.Errorf("token %d: want position %d:%d but got %d:%d", i, expected[i].line, expected[i].column, item.Pos.Line, item.Pos.Column)
+		}
+	}
+}
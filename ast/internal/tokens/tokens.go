@@ -16,12 +16,20 @@ const (
 	Whitespace
 	Identifier
 	Field
-	Comment // TODO
+	Comment
 
 	Package
-	Import // TODO
-	Else   // TODO
-	Null   // TODO
+	Import
+	As
+	Default
+	Contains
+	Not
+	Some
+	In
+	Every
+	With
+	Else
+	Null
 	True
 	False
 
@@ -36,7 +44,7 @@ const (
 	LParenthesis
 	RParenthesis
 	Comma
-	Colon // TODO
+	Colon
 	Declare
 	Assign
 
@@ -45,8 +53,9 @@ const (
 	Multiply
 	Divide
 	Modulus
-	And       // TODO
-	Or        // TODO
+	And       // set intersection
+	Or        // set union
+	Bang      // boolean not
 	NEqual    // not equal
 	Equal     // equal
 	LT        // less than
@@ -54,7 +63,7 @@ const (
 	LTE       // less than or equal
 	GTE       // greater than or equal
 	Dot       // TODO
-	Semicolon // TODO
+	Semicolon // expression separator
 )
 
 var strings = [...]string{
@@ -67,6 +76,14 @@ var strings = [...]string{
 	Comment:      "comment",
 	Package:      "package",
 	Import:       "import",
+	As:           "as",
+	Default:      "default",
+	Contains:     "contains",
+	Not:          "not",
+	Some:         "some",
+	In:           "in",
+	Every:        "every",
+	With:         "with",
 	Else:         "else",
 	Null:         "null",
 	True:         "true",
@@ -83,7 +100,7 @@ var strings = [...]string{
 	Comma:        ",",
 	Colon:        ":",
 	Declare:      "declare",
-	Assign:       "=",
+	Assign:       "unify",    // =
 	Add:          "add",      // +
 	Subtract:     "minus",    // -
 	Multiply:     "multiply", // *
@@ -91,6 +108,7 @@ var strings = [...]string{
 	Modulus:      "modulus",  // %
 	And:          "and",      // &
 	Or:           "or",       // |
+	Bang:         "bang",     // !
 	NEqual:       "nEqual",   // !=
 	Equal:        "equal",    // ==
 	LT:           "lt",
@@ -102,12 +120,20 @@ var strings = [...]string{
 }
 
 var keywords = map[string]Token{
-	"package": Package,
-	"import":  Import,
-	"else":    Else,
-	"null":    Null,
-	"true":    True,
-	"false":   False,
+	"package":  Package,
+	"import":   Import,
+	"as":       As,
+	"default":  Default,
+	"contains": Contains,
+	"not":      Not,
+	"some":     Some,
+	"in":       In,
+	"every":    Every,
+	"with":     With,
+	"else":     Else,
+	"null":     Null,
+	"true":     True,
+	"false":    False,
 }
 
 func Keyword(lit string) Token {
@@ -0,0 +1,358 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/internal/lexer"
+	"avidbound.com/zego/ast/internal/tokens"
+	"avidbound.com/zego/ast/term"
+)
+
+// metadataMarker is the text of the comment that starts a metadata block.
+const metadataMarker = "METADATA"
+
+// parseAnnotations parses the metadata blocks among the comments and attaches
+// them to the package or rule that follows each of them. A metadata block is
+// a comment reading METADATA followed by comments on the lines below it, e.g.
+//
+//	# METADATA
+//	# title: Allow admins
+//	allow := true { input.user.admin }
+func (p *parser) parseAnnotations(stmts []ast.Statement) {
+	for _, block := range p.metadataBlocks() {
+		a := p.parseMetadata(block)
+		if a == nil {
+			continue
+		}
+
+		switch stmt := p.annotated(stmts, a.Location).(type) {
+		case *ast.Package:
+			if a.Scope == "" {
+				a.Scope = ast.AnnotationScopePackage
+			}
+			if a.Scope != ast.AnnotationScopePackage && a.Scope != ast.AnnotationScopeSubpackages {
+				p.errorf(a.Location, "invalid metadata: scope %q is not allowed on a package", a.Scope)
+				continue
+			}
+			stmt.Annotations = append(stmt.Annotations, a)
+		case *ast.Rule:
+			if a.Scope == "" {
+				a.Scope = ast.AnnotationScopeRule
+			}
+			if a.Scope != ast.AnnotationScopeRule && a.Scope != ast.AnnotationScopeDocument {
+				p.errorf(a.Location, "invalid metadata: scope %q is not allowed on a rule", a.Scope)
+				continue
+			}
+			stmt.Annotations = append(stmt.Annotations, a)
+		default:
+			p.errorf(a.Location, "metadata must be followed by a package or rule")
+		}
+	}
+}
+
+// metadataBlocks returns the comments of each metadata block. The comments of
+// a block are on consecutive lines of their own and start at the same column.
+func (p *parser) metadataBlocks() [][]*ast.Comment {
+	var blocks [][]*ast.Comment
+	for i := 0; i < len(p.comments); i++ {
+		c := p.comments[i]
+		if !isMetadataMarker(c) || !p.ownLine(c) {
+			continue
+		}
+		block := []*ast.Comment{c}
+		for ; i+1 < len(p.comments); i++ {
+			next, last := p.comments[i+1], block[len(block)-1]
+			if next.Location.Line != last.Location.Line+1 || next.Location.Column != c.Location.Column ||
+				isMetadataMarker(next) || !p.ownLine(next) {
+				break
+			}
+			block = append(block, next)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func isMetadataMarker(c *ast.Comment) bool {
+	return strings.TrimSpace(string(c.Text)) == metadataMarker
+}
+
+// ownLine returns true if nothing precedes the comment on its line.
+func (p *parser) ownLine(c *ast.Comment) bool {
+	before := p.source[:c.Location.Offset]
+	start := bytes.LastIndexByte(before, '\n') + 1
+	return len(bytes.TrimSpace(before[start:])) == 0
+}
+
+// annotated returns the statement following the metadata block at loc or nil
+// if the block is not followed by a statement or is inside of one.
+func (p *parser) annotated(stmts []ast.Statement, loc *term.Location) ast.Statement {
+	for _, stmt := range stmts {
+		switch l := stmt.Loc(); {
+		case l.Offset >= loc.EndOffset:
+			return stmt
+		case l.EndOffset > loc.Offset:
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseMetadata returns the annotations given by the comments of the block,
+// the first of which is the marker. Errors in a field are reported at the
+// comment holding it, other errors at the block.
+func (p *parser) parseMetadata(block []*ast.Comment) *ast.Annotations {
+	first, last := block[0].Location, block[len(block)-1].Location
+	loc := p.location(
+		lexer.Pos{Index: first.Offset, Line: first.Line, Column: first.Column},
+		lexer.Pos{Index: last.EndOffset, Line: last.EndLine, Column: last.EndColumn},
+	)
+
+	lines := make([]string, len(block)-1)
+	for i, c := range block[1:] {
+		lines[i] = string(c.Text)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &doc); err != nil {
+		p.errorf(loc, "invalid metadata: %v", err)
+		return nil
+	}
+
+	// the lines of the document are those of the comments following the
+	// marker
+	at := func(n *yaml.Node) *term.Location {
+		if n.Line >= 1 && n.Line < len(block) {
+			return block[n.Line].Location
+		}
+		return loc
+	}
+
+	a, n, err := p.annotations(&doc)
+	if err != nil {
+		if n != nil {
+			p.errorf(at(n), "invalid metadata: %v", err)
+		} else {
+			p.errorf(loc, "invalid metadata: %v", err)
+		}
+		return nil
+	}
+	a.SetLoc(loc)
+	return a
+}
+
+// metadataJSON returns v with the keys of every mapping as strings, as in JSON.
+// Mappings whose keys are not all strings, e.g. 1: a, are decoded with
+// interface{} keys.
+func metadataJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, x := range v {
+			v[k] = metadataJSON(x)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			m[fmt.Sprint(k)] = metadataJSON(x)
+		}
+		return m
+	case []interface{}:
+		for i, x := range v {
+			v[i] = metadataJSON(x)
+		}
+	}
+	return v
+}
+
+// annotations returns the annotations given by the fields of the metadata
+// document. If a field is invalid, the node of its key is returned along with
+// the error.
+func (p *parser) annotations(doc *yaml.Node) (*ast.Annotations, *yaml.Node, error) {
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("expected fields")
+	}
+	fields := doc.Content[0].Content
+
+	a := &ast.Annotations{}
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		key, k := fields[i], fields[i].Value
+		if seen[k] {
+			return nil, key, fmt.Errorf("duplicate field %q", k)
+		}
+		seen[k] = true
+
+		var v interface{}
+		if err := fields[i+1].Decode(&v); err != nil {
+			return nil, key, err
+		}
+		v = metadataJSON(v)
+
+		var err error
+		var ok bool
+		switch k {
+		case "scope":
+			if a.Scope, err = metadataString(k, v); err == nil {
+				switch a.Scope {
+				case ast.AnnotationScopePackage, ast.AnnotationScopeSubpackages, ast.AnnotationScopeRule, ast.AnnotationScopeDocument:
+				default:
+					err = fmt.Errorf("invalid scope %q", a.Scope)
+				}
+			}
+		case "title":
+			a.Title, err = metadataString(k, v)
+		case "description":
+			a.Description, err = metadataString(k, v)
+		case "authors":
+			a.Authors, err = metadataAuthors(v)
+		case "entrypoint":
+			if a.Entrypoint, ok = v.(bool); !ok {
+				err = fmt.Errorf("entrypoint must be a boolean")
+			}
+		case "schemas":
+			a.Schemas, err = p.metadataSchemas(v)
+		case "custom":
+			if a.Custom, ok = v.(map[string]interface{}); !ok && v != nil {
+				err = fmt.Errorf("custom must be a mapping")
+			}
+		default:
+			err = fmt.Errorf("unknown field %q", k)
+		}
+		if err != nil {
+			return nil, key, err
+		}
+	}
+	return a, nil, nil
+}
+
+func metadataString(field string, v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", field)
+	}
+	return s, nil
+}
+
+var authorPattern = regexp.MustCompile(`^(.*?)\s*<([^<>]+)>$`)
+
+// metadataAuthors returns the authors given as a list of strings, e.g.
+// "Jane Doe <jane@example.com>", or mappings with a name and email.
+func metadataAuthors(v interface{}) ([]*ast.AuthorAnnotation, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+
+	authors := make([]*ast.AuthorAnnotation, 0, len(list))
+	for _, x := range list {
+		author := &ast.AuthorAnnotation{}
+		switch x := x.(type) {
+		case string:
+			s := strings.TrimSpace(x)
+			switch m := authorPattern.FindStringSubmatch(s); {
+			case m != nil:
+				author.Name, author.Email = m[1], m[2]
+			case strings.Contains(s, "@") && !strings.Contains(s, " "):
+				author.Email = s
+			default:
+				author.Name = s
+			}
+		case map[string]interface{}:
+			for k, v := range x {
+				s, ok := v.(string)
+				switch {
+				case k != "name" && k != "email":
+					return nil, fmt.Errorf("unknown author field %q", k)
+				case !ok:
+					return nil, fmt.Errorf("author %s must be a string", k)
+				case k == "name":
+					author.Name = s
+				default:
+					author.Email = s
+				}
+			}
+		default:
+			return nil, fmt.Errorf("author must be a string or a mapping")
+		}
+		if author.Name == "" && author.Email == "" {
+			return nil, fmt.Errorf("author must have a name or email")
+		}
+		authors = append(authors, author)
+	}
+	return authors, nil
+}
+
+// metadataSchemas returns the schemas given as a list of mappings from the
+// path of a document to a schema reference or definition, e.g.
+// - input.user: schema.user.
+func (p *parser) metadataSchemas(v interface{}) ([]*ast.SchemaAnnotation, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schemas must be a list")
+	}
+
+	schemas := make([]*ast.SchemaAnnotation, 0, len(list))
+	for _, x := range list {
+		m, ok := x.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return nil, fmt.Errorf("schema must be a mapping from a path to a schema")
+		}
+		for k, v := range m {
+			path, err := p.metadataRef(k)
+			if err != nil {
+				return nil, err
+			}
+			if head := path[0].Value; !head.Equal(ast.InputRootDocument.Value) && !head.Equal(ast.RootDocument.Value) {
+				return nil, fmt.Errorf("schema path %v must begin with %v or %v", path, ast.InputRootDocument, ast.RootDocument)
+			}
+
+			schema := &ast.SchemaAnnotation{Path: path}
+			switch v := v.(type) {
+			case string:
+				if schema.Schema, err = p.metadataRef(v); err != nil {
+					return nil, err
+				}
+				if !schema.Schema[0].Value.Equal(term.Var("schema")) {
+					return nil, fmt.Errorf("schema %v must begin with schema", schema.Schema)
+				}
+			case map[string]interface{}:
+				schema.Definition = v
+			default:
+				return nil, fmt.Errorf("schema of %v must be a reference or a definition", path)
+			}
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas, nil
+}
+
+// metadataRef parses a reference with a static path, e.g. input.user.
+func (p *parser) metadataRef(s string) (term.Ref, error) {
+	q := NewParser(p.file, s)
+	t := q.parseTerm()
+	if t == nil || len(q.errors) > 0 || q.token() != tokens.EOF {
+		return nil, fmt.Errorf("invalid reference %q", s)
+	}
+
+	switch v := t.Value.(type) {
+	case term.Var:
+		return term.Ref{t}, nil
+	case term.Ref:
+		if _, ok := v[0].Value.(term.Var); !ok {
+			break
+		}
+		for _, x := range v[1:] {
+			if _, ok := x.Value.(term.String); !ok {
+				return nil, fmt.Errorf("invalid reference %q", s)
+			}
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid reference %q", s)
+}
@@ -16,22 +16,53 @@ type state struct {
 	index  int
 }
 
+// DefaultMaxErrors is the number of errors after which parsing stops unless
+// configured otherwise in ParserOptions.
+const DefaultMaxErrors = 10
+
 type parser struct {
-	file   string
-	items  []lexer.Item
-	errors ast.Errors
-	index  int
+	file      string
+	source    []byte // the input shared by the Text of every location
+	items     []lexer.Item
+	errors    ast.Errors
+	maxErrors int // no limit if not positive
+	index     int
+	noOr      bool // true while parsing the head of a comprehension
+	depth     int  // number of enclosing brackets within the current body
+	comments  []*ast.Comment
 }
 
 func NewParser(name, input string) *parser {
 	p := &parser{
-		file:  name,
-		items: lexer.Lex(name, input),
+		file:      name,
+		source:    []byte(input),
+		maxErrors: DefaultMaxErrors,
+	}
+	// comments are collected apart from the items, the grammar never sees
+	// them
+	for _, item := range lexer.Lex(name, input) {
+		if item.Token == tokens.Comment {
+			loc := p.location(item.Pos, item.End)
+			c := &ast.Comment{Text: loc.Text[1:]}
+			c.SetLoc(loc)
+			p.comments = append(p.comments, c)
+			continue
+		}
+		p.items = append(p.items, item)
 	}
 	return p
 }
 
-// parse runs the state machine for the parser.
+func newParserWithOpts(name, input string, opts ParserOptions) *parser {
+	p := NewParser(name, input)
+	if opts.MaxErrors != 0 {
+		p.maxErrors = opts.MaxErrors
+	}
+	return p
+}
+
+// parse runs the state machine for the parser. Statements that fail to parse
+// are skipped, so that the errors of all statements are reported at once.
 func (p *parser) parse() ([]ast.Statement, error) {
 	var statements []ast.Statement
 
@@ -40,32 +71,134 @@ func (p *parser) parse() ([]ast.Statement, error) {
 	}
 
 Loop:
-	for {
+	for !p.tooManyErrors() {
+		start, errs := p.index, len(p.errors)
+
 		tok := p.token()
 		switch tok {
-		case tokens.Illegal:
-			p.errorf(p.loc(), p.items[p.index].Value)
 		case tokens.Package:
 			if pkg := p.parsePackage(); pkg != nil {
 				statements = append(statements, pkg)
 			}
+		case tokens.Import:
+			if imp := p.parseImport(); imp != nil {
+				statements = append(statements, imp)
+			}
+		case tokens.Default:
+			if rule := p.parseDefaultRule(); rule != nil {
+				statements = append(statements, rule)
+			}
 		case tokens.Identifier:
 			if rule := p.parseRule(); rule != nil {
 				statements = append(statements, rule)
 			}
 		case tokens.EOF:
 			break Loop
+		default:
+			p.errorf(p.loc(), "unexpected %s token", tok)
 		}
 
-		if len(p.errors) > 0 {
-			return nil, p.errors
+		if len(p.errors) > errs {
+			if p.illegal(start, p.index+1) {
+				// the statement failed on input the lexer reports
+				p.errors = p.errors[:errs]
+			}
+			p.resync(start)
+			p.lexErrors(start, p.index)
 		}
 	}
 
+	p.parseAnnotations(statements)
+
+	if len(p.errors) > 0 {
+		return nil, p.errors
+	}
+
 	return statements, nil
 }
 
+// lexErrors reports the errors of the lexer from index start up to end.
+func (p *parser) lexErrors(start, end int) {
+	for i := start; i < end && i < len(p.items); i++ {
+		if item := p.items[i]; item.Token == tokens.Illegal {
+			p.errorf(p.location(item.Pos, item.End), "%s", item.Value)
+		}
+	}
+}
+
+// illegal returns true if the lexer reports an error from index start up to
+// end.
+func (p *parser) illegal(start, end int) bool {
+	for i := start; i < end && i < len(p.items); i++ {
+		if p.items[i].Token == tokens.Illegal {
+			return true
+		}
+	}
+	return false
+}
+
+// resync advances the parser to the statement following the one that starts at
+// index start and failed to parse. A statement ends at the end of a line or a
+// closing brace outside of any brackets that is followed by a package, import,
+// default or rule head.
+func (p *parser) resync(start int) {
+	var open []tokens.Token
+	for i := start; i < len(p.items); i++ {
+		tok := p.items[i].Token
+		switch tok {
+		case tokens.LBrace, tokens.LBracket, tokens.LParenthesis:
+			open = append(open, tok)
+		case tokens.RBrace, tokens.RBracket, tokens.RParenthesis:
+			// like the lexer, blocks left open inside of the closed block
+			// are closed as well
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == opening[tok] {
+					open = open[:j]
+					break
+				}
+			}
+		case tokens.EOF:
+			p.index = i
+			return
+		}
+
+		if i < p.index || len(open) > 0 || (tok != tokens.EOL && tok != tokens.RBrace) {
+			continue
+		}
+
+		j := i + 1
+		for j < len(p.items) && (p.items[j].Token == tokens.Whitespace || p.items[j].Token == tokens.EOL) {
+			j++
+		}
+		if j == len(p.items) {
+			p.index = j
+			return
+		}
+		switch p.items[j].Token {
+		case tokens.Package, tokens.Import, tokens.Default, tokens.Identifier, tokens.EOF:
+			p.index = j
+			return
+		}
+	}
+	p.index = len(p.items)
+}
+
+var opening = map[tokens.Token]tokens.Token{
+	tokens.RBrace:       tokens.LBrace,
+	tokens.RBracket:     tokens.LBracket,
+	tokens.RParenthesis: tokens.LParenthesis,
+}
+
+// tooManyErrors returns true once the maximum number of errors is reached.
+func (p *parser) tooManyErrors() bool {
+	return p.maxErrors > 0 && len(p.errors) >= p.maxErrors
+}
+
 func (p *parser) parseQuery() (ast.Body, ast.Errors) {
+	if p.lexErrors(0, len(p.items)); len(p.errors) > 0 {
+		return nil, p.errors
+	}
+
 	if p.token() == tokens.Whitespace || p.token() == tokens.EOL {
 		p.nextNonSpace()
 	}
@@ -103,11 +236,64 @@ func (p *parser) parsePackage() *ast.Package {
 		break
 	}
 
+	pkg.SetLoc(p.span(pkg.Location))
 	return pkg
 }
 
+// parseImport parses an import of a path rooted at zego or input with an
+// optional alias, e.g. import input.request as req.
+func (p *parser) parseImport() *ast.Import {
+	imp := &ast.Import{}
+	imp.SetLoc(p.loc())
+
+	if p.nextNonSpace() != tokens.Identifier {
+		p.errorf(p.loc(), "expected import path")
+		return nil
+	}
+
+	path := p.parseTerm()
+	if path == nil {
+		return nil
+	}
+
+	var head term.Value
+	switch v := path.Value.(type) {
+	case term.Var:
+		head = v
+	case term.Ref:
+		head = v[0].Value
+		for _, x := range v[1:] {
+			if _, ok := x.Value.(term.String); !ok {
+				p.errorf(x.Location, "invalid import path %v: path elements must be strings", path)
+				return nil
+			}
+		}
+	default:
+		p.errorf(path.Location, "invalid import path %v", path)
+		return nil
+	}
+	if !head.Equal(ast.RootDocument.Value) && !head.Equal(ast.InputRootDocument.Value) {
+		p.errorf(path.Location, "invalid import path %v: path must begin with %v or %v", path, ast.RootDocument, ast.InputRootDocument)
+		return nil
+	}
+	imp.Path = path
+
+	if p.token() == tokens.As {
+		if p.nextNonSpace() != tokens.Identifier {
+			p.errorf(p.loc(), "expected import alias")
+			return nil
+		}
+		imp.Alias = term.Var(p.items[p.index].Value)
+		p.nextNonSpace()
+	}
+
+	imp.SetLoc(p.span(imp.Location))
+	return imp
+}
+
 func (p *parser) parseRule() *ast.Rule {
 	rule := &ast.Rule{}
+	rule.SetLoc(p.loc())
 
 	if name := p.parseVar(); name != nil {
 		if v, ok := name.Value.(term.Var); ok {
@@ -118,29 +304,150 @@ func (p *parser) parseRule() *ast.Rule {
 		p.errorf(p.loc(), "expected rule head name")
 	}
 
-	p.nextNonSpace()
+	switch p.nextNonSpace() {
+	case tokens.LParenthesis:
+		if rule.Args = p.parseArgs(); rule.Args == nil {
+			return nil
+		}
+	case tokens.LBracket: // example: headers[k] := v {}
+		p.nextNonSpace()
+		if rule.Key = p.parseTermRelation(nil); rule.Key == nil {
+			return nil
+		}
+		if p.token() != tokens.RBracket {
+			p.errorf(p.loc(), "expected %q", tokens.RBracket)
+			return nil
+		}
+		p.nextNonSpace()
+	case tokens.Contains: // example: deny contains msg {}
+		p.nextNonSpace()
+		if rule.Key = p.parseTermRelation(nil); rule.Key == nil {
+			return nil
+		}
+		if !p.parseRuleBody(rule) {
+			return nil
+		}
+		if p.token() == tokens.Else {
+			p.errorf(p.loc(), "else is not allowed on partial rules")
+			return nil
+		}
+		rule.SetLoc(p.span(rule.Location))
+		return rule
+	}
 
 	if p.token() != tokens.Declare {
 		p.errorf(p.loc(), "rules must use := operator")
 		return nil
 	}
 
+	if !p.parseRuleValue(rule) {
+		return nil
+	}
+
+	if rule.Key != nil && p.token() == tokens.Else {
+		p.errorf(p.loc(), "else is not allowed on partial rules")
+		return nil
+	}
+
+	// example: rule := 1 { a } else := 2 { b } else := 3
+	for prev := rule; p.token() == tokens.Else; prev = prev.Else {
+		prev.Else = &ast.Rule{
+			Name: rule.Name,
+			Args: rule.Args,
+		}
+		prev.Else.SetLoc(p.loc())
+
+		if p.nextNonSpace() != tokens.Declare {
+			p.errorf(p.loc(), "else must use := operator")
+			return nil
+		}
+		if !p.parseRuleValue(prev.Else) {
+			return nil
+		}
+		prev.Else.SetLoc(p.span(prev.Else.Location))
+	}
+
+	rule.SetLoc(p.span(rule.Location))
+	return rule
+}
+
+// parseDefaultRule parses a default rule, e.g. default allow := false. Default
+// rules only have a value.
+func (p *parser) parseDefaultRule() *ast.Rule {
+	loc := p.loc()
+
+	if p.nextNonSpace() != tokens.Identifier {
+		p.errorf(p.loc(), "expected rule head name")
+		return nil
+	}
+
+	rule := p.parseRule()
+	if rule == nil {
+		return nil
+	}
+	rule.SetLoc(p.span(loc))
+	rule.Default = true
+
+	switch {
+	case rule.IsFunction():
+		p.errorf(loc, "default rules cannot have arguments")
+	case rule.Key != nil:
+		p.errorf(loc, "default rules cannot be partial")
+	case rule.Body != nil:
+		p.errorf(loc, "default rules cannot have a body")
+	case rule.Else != nil:
+		p.errorf(loc, "default rules cannot have else")
+	default:
+		return rule
+	}
+	return nil
+}
+
+// parseRuleValue parses the value and optional body of a rule following the
+// := operator.
+func (p *parser) parseRuleValue(rule *ast.Rule) bool {
 	p.nextNonSpace()
 
 	rule.Value = p.parseTermRelation(nil) // example: rule := a+b {}
 
+	return p.parseRuleBody(rule)
+}
+
+// parseRuleBody parses the optional body of a rule.
+func (p *parser) parseRuleBody(rule *ast.Rule) bool {
 	if p.token() == tokens.LBrace {
 		p.nextNonSpace()
 		if rule.Body = p.parseBody(tokens.RBrace); rule.Body == nil {
-			return nil
+			return false
 		}
 		p.nextNonSpace()
 	}
 
-	return rule
+	return true
+}
+
+// parseArgs parses the arguments of a function head, e.g. (user, [a, b]).
+// Functions without arguments return empty, non-nil args.
+func (p *parser) parseArgs() ast.Args {
+	if p.nextNonSpace() == tokens.RParenthesis {
+		p.nextNonSpace()
+		return ast.Args{}
+	}
+
+	args := p.parseTermList(tokens.RParenthesis, nil)
+	if args == nil {
+		return nil
+	}
+	p.nextNonSpace()
+	return ast.Args(args)
 }
 
 func (p *parser) parseBody(end tokens.Token) ast.Body {
+	// expressions of a body end at a line break, even inside of brackets
+	depth := p.depth
+	p.depth = 0
+	defer func() { p.depth = depth }()
+
 	body := ast.Body{}
 
 	if p.token() == end {
@@ -156,6 +463,10 @@ func (p *parser) parseBody(end tokens.Token) ast.Body {
 
 		body.Append(expr)
 
+		if p.token() == tokens.Semicolon {
+			p.nextNonSpace()
+		}
+
 		if p.token() == end {
 			return body
 		}
@@ -163,31 +474,219 @@ func (p *parser) parseBody(end tokens.Token) ast.Body {
 }
 
 func (p *parser) parseExpr() *ast.Expr {
+	if p.token() == tokens.Not {
+		loc := p.loc()
+		p.nextNonSpace()
+		expr := p.parseExpr()
+		if expr == nil {
+			return nil
+		}
+		switch {
+		case expr.IsDeclare():
+			p.errorf(loc, "cannot negate declaration")
+			return nil
+		case expr.IsSome():
+			p.errorf(loc, "cannot negate some declaration")
+			return nil
+		case expr.IsEvery():
+			p.errorf(loc, "cannot negate every expression")
+			return nil
+		case expr.Negated:
+			p.errorf(loc, "cannot negate negated expression")
+			return nil
+		}
+		expr.Negated = true
+		expr.SetLoc(p.span(loc))
+		return expr
+	}
+
+	var expr *ast.Expr
+	switch p.token() {
+	case tokens.Some:
+		expr = p.parseSome()
+	case tokens.Every:
+		expr = p.parseEvery()
+	default:
+		expr = p.parseExprTerms()
+	}
+	if expr == nil {
+		return nil
+	}
+
+	for p.token() == tokens.With {
+		w := p.parseWith()
+		if w == nil {
+			return nil
+		}
+		expr.With = append(expr.With, w)
+	}
+	expr.SetLoc(p.span(expr.Location))
+	return expr
+}
+
+// parseExprTerms parses an expression consisting of a single term, a
+// declaration or a unification.
+func (p *parser) parseExprTerms() *ast.Expr {
 	lhs := p.parseTermRelation(nil)
 	if lhs == nil {
 		return nil
 	}
 
 	tok := p.token()
-	if tok == tokens.Declare {
+	if tok == tokens.Declare || tok == tokens.Assign {
 		loc := p.loc()
 		p.nextNonSpace()
 		if rhs := p.parseTermRelation(nil); rhs != nil {
 			op := term.OpTerm(tok.String()).SetLoc(loc)
-			return ast.NewExpr(term.CallTerm(op, lhs, rhs))
+			expr := ast.NewExpr(term.CallTerm(op, lhs, rhs).SetLoc(p.span(lhs.Location)))
+			expr.SetLoc(lhs.Location)
+			return expr
+		}
+		return nil
+	}
+
+	expr := ast.NewExpr(lhs)
+	expr.SetLoc(lhs.Location)
+	return expr
+}
+
+// parseWith parses a modifier of an expression, e.g. with input.user as "a".
+// The target must be a variable or a reference with a static path.
+func (p *parser) parseWith() *ast.With {
+	loc := p.loc()
+	p.nextNonSpace()
+
+	target := p.parseTerm()
+	if target == nil {
+		return nil
+	}
+	if !isWithTarget(target) {
+		p.errorf(target.Location, "invalid with target %v", target)
+		return nil
+	}
+
+	if p.token() != tokens.As {
+		p.errorf(p.loc(), "expected %q", tokens.As)
+		return nil
+	}
+	p.nextNonSpace()
+
+	value := p.parseTermRelation(nil)
+	if value == nil {
+		return nil
+	}
+
+	w := &ast.With{Target: target, Value: value}
+	w.SetLoc(p.span(loc))
+	return w
+}
+
+func isWithTarget(t *term.Term) bool {
+	switch v := t.Value.(type) {
+	case term.Var:
+		return !ast.IsWildcard(v)
+	case term.Ref:
+		if _, ok := v[0].Value.(term.Var); !ok {
+			return false
 		}
+		for _, x := range v[1:] {
+			if _, ok := x.Value.(term.String); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// parseSome parses the iteration over a collection, e.g. some x in coll or
+// some k, v in coll.
+func (p *parser) parseSome() *ast.Expr {
+	loc := p.loc()
+	p.nextNonSpace()
+
+	some := &ast.SomeDecl{}
+	if !p.parseIn(&some.Key, &some.Value, &some.Collection) {
 		return nil
 	}
 
-	// if call, ok := lhs.Value.(term.Call); ok {
-	// 	return ast.NewExpr([]*term.Term(call))
-	// }
+	expr := ast.NewExpr(some)
+	expr.SetLoc(loc)
+	return expr
+}
 
-	return ast.NewExpr(lhs)
+// parseEvery parses the universal quantification over a collection, e.g.
+// every x in coll { x > 1 }.
+func (p *parser) parseEvery() *ast.Expr {
+	loc := p.loc()
+	p.nextNonSpace()
+
+	every := &ast.Every{}
+	if !p.parseIn(&every.Key, &every.Value, &every.Collection) {
+		return nil
+	}
+
+	if p.token() != tokens.LBrace {
+		p.errorf(p.loc(), "expected %q", tokens.LBrace)
+		return nil
+	}
+	p.nextNonSpace()
+	if every.Body = p.parseBody(tokens.RBrace); every.Body == nil {
+		return nil
+	}
+	p.nextNonSpace()
+
+	expr := ast.NewExpr(every)
+	expr.SetLoc(loc)
+	return expr
+}
+
+// parseIn parses the variables and collection of some and every, e.g.
+// k, v in coll. The key is left unset if only the value is given.
+func (p *parser) parseIn(key, value, coll **term.Term) bool {
+	t := p.parseTermRelation(nil)
+	if t == nil {
+		return false
+	}
+	if p.token() == tokens.Comma {
+		*key = t
+		p.nextNonSpace()
+		if t = p.parseTermRelation(nil); t == nil {
+			return false
+		}
+	}
+
+	call, ok := t.Value.(term.Call)
+	if !ok || len(call) != 3 || !call[0].Value.Equal(term.Op(tokens.In.String())) {
+		p.errorf(t.Location, "expected %q", tokens.In)
+		return false
+	}
+	*value = call[1]
+	*coll = call[2]
+
+	for _, x := range []*term.Term{*key, *value} {
+		if x == nil {
+			continue
+		}
+		if _, ok := x.Value.(term.Var); !ok {
+			p.errorf(x.Location, "expected variable but got %v", x)
+			return false
+		}
+	}
+	return true
 }
 
 func (p *parser) parseTerm() *term.Term {
+	// the head of a comprehension only ends at the top level
+	noOr := p.noOr
+	p.noOr = false
+	defer func() { p.noOr = noOr }()
+
 	switch p.token() {
+	case tokens.Null:
+		term := term.NullTerm().SetLoc(p.loc())
+		p.nextNonSpace()
+		return term
 	case tokens.True:
 		term := term.BooleanTerm(true).SetLoc(p.loc())
 		p.nextNonSpace()
@@ -200,18 +699,31 @@ func (p *parser) parseTerm() *term.Term {
 		term := p.parseString()
 		p.nextNonSpace()
 		return term
+	case tokens.RawString:
+		term := p.parseRawString()
+		p.nextNonSpace()
+		return term
 	case tokens.Identifier:
 		term := p.parseVar()
 		// check if next is ident.field or ident.field[_] or ident.call(_)
-		if tok := p.next(); tok == tokens.Field || tok == tokens.LParenthesis || tok == tokens.LBracket {
+		tok := p.next()
+		if tok == tokens.Field || tok == tokens.LParenthesis || tok == tokens.LBracket {
 			return p.parseRef(term)
 		}
+		p.skipSpace()
 		return term
 	case tokens.Number:
 		return p.parseNumber()
+	case tokens.LBracket:
+		return p.parseArray()
+	case tokens.LBrace:
+		return p.parseBraces()
 	case tokens.LParenthesis:
 		p.nextNonSpace()
-		if term := p.parseTermRelation(nil); term != nil {
+		p.depth++
+		term := p.parseTermRelation(nil)
+		p.depth--
+		if term != nil {
 			if p.token() != tokens.RParenthesis {
 				p.errorf(p.loc(), "non-terminated expression")
 				return nil
@@ -219,6 +731,7 @@ func (p *parser) parseTerm() *term.Term {
 			if tok := p.next(); tok == tokens.Field || tok == tokens.LParenthesis || tok == tokens.LBracket {
 				return p.parseRef(term)
 			}
+			p.skipSpace()
 			return term
 		} else {
 			return nil
@@ -234,6 +747,181 @@ func (p *parser) parseTerm() *term.Term {
 	}
 }
 
+// parseArray parses an array literal or an array comprehension.
+func (p *parser) parseArray() *term.Term {
+	loc := p.loc()
+	p.depth++
+	defer func() { p.depth-- }()
+
+	var t *term.Term
+	if p.nextNonSpace() == tokens.RBracket {
+		t = term.ArrayTerm().SetLoc(loc)
+	} else {
+		head := p.parseComprehensionHead()
+		if head == nil {
+			return nil
+		}
+		switch p.token() {
+		case tokens.Or:
+			t = p.parseComprehension(loc, tokens.RBracket, head, nil)
+		case tokens.RBracket:
+			t = term.ArrayTerm(head).SetLoc(loc)
+		case tokens.Comma:
+			elems := []*term.Term{head}
+			if p.nextNonSpace() != tokens.RBracket {
+				if elems = p.parseTermList(tokens.RBracket, elems); elems == nil {
+					return nil
+				}
+			}
+			t = term.ArrayTerm(elems...).SetLoc(loc)
+		default:
+			p.errorf(p.loc(), "expected %q or %q", tokens.Comma, tokens.RBracket)
+		}
+		if t == nil {
+			return nil
+		}
+	}
+
+	return p.parseTermSuffix(t)
+}
+
+// parseBraces parses an object or set literal, or one of their comprehensions.
+// A left brace at the start of a term always opens a literal, rule bodies are
+// only opened after the rule head. Empty braces are an empty object, the empty
+// set is written set().
+func (p *parser) parseBraces() *term.Term {
+	loc := p.loc()
+	p.depth++
+	defer func() { p.depth-- }()
+
+	var t *term.Term
+	if p.nextNonSpace() == tokens.RBrace {
+		t = term.ObjectTerm().SetLoc(loc)
+	} else {
+		head := p.parseComprehensionHead()
+		if head == nil {
+			return nil
+		}
+		switch p.token() {
+		case tokens.Or:
+			t = p.parseComprehension(loc, tokens.RBrace, head, nil)
+		case tokens.Colon:
+			p.nextNonSpace()
+			value := p.parseComprehensionHead()
+			if value == nil {
+				return nil
+			}
+			if p.token() == tokens.Or {
+				t = p.parseComprehension(loc, tokens.RBrace, head, value)
+			} else {
+				t = p.parseObject(loc, head, value)
+			}
+		default:
+			t = p.parseSet(loc, head)
+		}
+		if t == nil {
+			return nil
+		}
+	}
+
+	return p.parseTermSuffix(t)
+}
+
+// parseTermSuffix parses the rest of a collection after its closing token,
+// e.g. the reference in {"a": 1}.a or [1, 2][0].
+func (p *parser) parseTermSuffix(t *term.Term) *term.Term {
+	tok := p.next()
+	t.SetLoc(p.span(t.Location))
+	if tok == tokens.Field || tok == tokens.LBracket {
+		return p.parseRef(t)
+	}
+	p.skipSpace()
+	return t
+}
+
+// parseComprehension parses the body of a comprehension after its head, e.g.
+// [x | x := input.items[_]]. The value is only set for object comprehensions.
+func (p *parser) parseComprehension(loc *term.Location, end tokens.Token, head, value *term.Term) *term.Term {
+	p.nextNonSpace()
+	body := p.parseBody(end)
+	if body == nil {
+		return nil
+	}
+
+	switch {
+	case end == tokens.RBracket:
+		return ast.ArrayComprehensionTerm(head, body).SetLoc(loc)
+	case value != nil:
+		return ast.ObjectComprehensionTerm(head, value, body).SetLoc(loc)
+	default:
+		return ast.SetComprehensionTerm(head, body).SetLoc(loc)
+	}
+}
+
+// parseComprehensionHead parses the first term of a collection. A pipe after
+// it separates the head of a comprehension from its body.
+func (p *parser) parseComprehensionHead() *term.Term {
+	noOr := p.noOr
+	p.noOr = true
+	defer func() { p.noOr = noOr }()
+	return p.parseTermRelation(nil)
+}
+
+// parseObject parses the rest of an object literal after its first item.
+func (p *parser) parseObject(loc *term.Location, key, value *term.Term) *term.Term {
+	var pairs [][2]*term.Term
+	for {
+		for _, pair := range pairs {
+			if pair[0].Value.Compare(key.Value) == 0 {
+				p.errorf(key.Location, "duplicate key %v in object", key)
+				return nil
+			}
+		}
+		pairs = append(pairs, term.Item(key, value))
+
+		if p.token() == tokens.RBrace {
+			break
+		}
+		if p.token() != tokens.Comma {
+			p.errorf(p.loc(), "expected %q or %q", tokens.Comma, tokens.RBrace)
+			return nil
+		}
+		if p.nextNonSpace() == tokens.RBrace {
+			break
+		}
+		if key = p.parseTermRelation(nil); key == nil {
+			return nil
+		}
+		if p.token() != tokens.Colon {
+			p.errorf(p.loc(), "expected %q", tokens.Colon)
+			return nil
+		}
+		p.nextNonSpace()
+		if value = p.parseTermRelation(nil); value == nil {
+			return nil
+		}
+	}
+	return term.ObjectTerm(pairs...).SetLoc(loc)
+}
+
+// parseSet parses the rest of a set literal after its first element.
+func (p *parser) parseSet(loc *term.Location, elem *term.Term) *term.Term {
+	elems := []*term.Term{elem}
+	switch p.token() {
+	case tokens.RBrace:
+	case tokens.Comma:
+		if p.nextNonSpace() != tokens.RBrace {
+			if elems = p.parseTermList(tokens.RBrace, elems); elems == nil {
+				return nil
+			}
+		}
+	default:
+		p.errorf(p.loc(), "expected %q, %q or %q", tokens.Colon, tokens.Comma, tokens.RBrace)
+		return nil
+	}
+	return term.SetTerm(elems...).SetLoc(loc)
+}
+
 func (p *parser) parseString() *term.Term {
 	var s string
 	err := json.Unmarshal([]byte(p.items[p.index].Value), &s)
@@ -244,6 +932,13 @@ func (p *parser) parseString() *term.Term {
 	return term.StringTerm(s).SetLoc(p.loc())
 }
 
+// parseRawString returns the content between the backticks as is; raw strings
+// have no escape sequences and may span multiple lines.
+func (p *parser) parseRawString() *term.Term {
+	s := p.items[p.index].Value
+	return term.StringTerm(s[1 : len(s)-1]).SetLoc(p.loc())
+}
+
 func (p *parser) parseNumber() *term.Term {
 	loc := p.loc()
 
@@ -276,14 +971,14 @@ func (p *parser) parseNumber() *term.Term {
 }
 
 func (p *parser) parseVar() *term.Term {
-	v := p.items[p.index].Value // TODO: generate wildcard '_'
-	return term.VarTerm(v)
+	v := p.items[p.index].Value
+	return term.VarTerm(v).SetLoc(p.loc())
 }
 
 func (p *parser) parseRef(head *term.Term) *term.Term {
 	ref := []*term.Term{head}
 
-	loc := p.loc()
+	loc := head.Location
 
 	for {
 		switch p.token() {
@@ -292,17 +987,20 @@ func (p *parser) parseRef(head *term.Term) *term.Term {
 			ref = append(ref, term.StringTerm(field).SetLoc(p.loc()))
 			p.next()
 		case tokens.LParenthesis:
-			term := p.parseCall(term.RefTerm(ref...).SetLoc(loc))
+			term := p.parseCall(term.RefTerm(ref...).SetLoc(p.span(loc)))
 			if term != nil {
 				if tok := p.token(); tok == tokens.Field || tok == tokens.LBracket {
-					term = p.parseRef(term) // with 'method(x).something' OR 'method(x)[_]'
+					return p.parseRef(term) // with 'method(x).something' OR 'method(x)[_]'
 				}
-				p.next()
+				p.skipSpace()
 			}
 			return term
 		case tokens.LBracket:
 			p.nextNonSpace()
-			if term := p.parseTermRelation(nil); term != nil {
+			p.depth++
+			term := p.parseTermRelation(nil)
+			p.depth--
+			if term != nil {
 				if p.token() != tokens.RBracket {
 					p.errorf(p.loc(), "expected %v", tokens.RBracket)
 					return nil
@@ -314,24 +1012,28 @@ func (p *parser) parseRef(head *term.Term) *term.Term {
 			}
 			break
 		default:
-			if p.token() == tokens.Whitespace || p.token() == tokens.EOL {
-				p.nextNonSpace()
-			}
-			return term.RefTerm(ref...)
+			p.skipSpace()
+			return term.RefTerm(ref...).SetLoc(p.span(loc))
 		}
 	}
 }
 
 func (p *parser) parseCall(operator *term.Term) *term.Term {
 	p.nextNonSpace()
+	p.depth++
+	defer func() { p.depth-- }()
 
 	if p.token() == tokens.RParenthesis {
-		return term.CallTerm(operator)
+		p.next()
+		if operator.Value.Equal(term.Ref{term.VarTerm("set")}) {
+			return term.SetTerm().SetLoc(p.span(operator.Location)) // set() is the empty set
+		}
+		return term.CallTerm(operator).SetLoc(p.span(operator.Location))
 	}
 
 	if r := p.parseTermList(tokens.RParenthesis, []*term.Term{operator}); r != nil {
 		p.next()
-		return term.CallTerm(r...)
+		return term.CallTerm(r...).SetLoc(p.span(operator.Location))
 	}
 
 	return nil
@@ -359,85 +1061,144 @@ func (p *parser) parseTermList(end tokens.Token, r []*term.Term) []*term.Term {
 	}
 }
 
+// Precedence levels of the operators, from the loosest to the tightest
+// binding. Prefix operators bind tighter than any infix operator.
+const (
+	precLowest = iota
+	precCompare
+	precOr
+	precAnd
+	precAdditive
+	precMultiplicative
+	precUnary
+)
+
+// Associativity of the infix operators. Left associative operators group from
+// the left, e.g. a - b - c is (a - b) - c, right associative operators group
+// from the right.
+const (
+	assocLeft = iota
+	assocRight
+)
+
+type infixOperator struct {
+	prec  int
+	assoc int
+}
+
+// infixOperators holds the precedence and associativity of each infix
+// operator.
+var infixOperators = map[tokens.Token]infixOperator{
+	tokens.Equal:    {precCompare, assocLeft},
+	tokens.NEqual:   {precCompare, assocLeft},
+	tokens.LT:       {precCompare, assocLeft},
+	tokens.GT:       {precCompare, assocLeft},
+	tokens.LTE:      {precCompare, assocLeft},
+	tokens.GTE:      {precCompare, assocLeft},
+	tokens.In:       {precCompare, assocLeft},
+	tokens.Or:       {precOr, assocLeft},
+	tokens.And:      {precAnd, assocLeft},
+	tokens.Add:      {precAdditive, assocLeft},
+	tokens.Subtract: {precAdditive, assocLeft},
+	tokens.Multiply: {precMultiplicative, assocLeft},
+	tokens.Divide:   {precMultiplicative, assocLeft},
+	tokens.Modulus:  {precMultiplicative, assocLeft},
+}
+
+// parseTermRelation parses a term followed by any number of infix operators
+// and their operands, e.g. a + b * c. If lhs is set it is used as the first
+// operand.
 func (p *parser) parseTermRelation(lhs *term.Term) *term.Term {
 	if lhs == nil {
-		lhs = p.parseTerm()
+		if lhs = p.parseUnary(); lhs == nil {
+			return nil
+		}
 	}
+	return p.parseInfix(lhs, precLowest)
+}
 
-	if lhs != nil {
+// parseInfix parses the operators following lhs that bind tighter than prec,
+// the operands of each operator are grouped by precedence climbing. Outside of
+// brackets an operator at the start of a line begins the next expression.
+func (p *parser) parseInfix(lhs *term.Term, prec int) *term.Term {
+	for {
 		tok := p.token()
 		if tok == tokens.Whitespace {
 			tok = p.nextNonSpace()
 		}
+
+		op, ok := infixOperators[tok]
+		if !ok || op.prec <= prec || (tok == tokens.Or && p.noOr) || (p.depth == 0 && p.lineBreak()) {
+			return lhs
+		}
 		loc := p.loc()
+		p.nextNonSpace()
 
-		if tok == tokens.Multiply || tok == tokens.Divide || tok == tokens.Modulus {
-			p.nextNonSpace()
-			if rhs := p.parseTermRelation(nil); rhs != nil {
-				op := term.OpTerm(tok.String()).SetLoc(loc)
-				call := term.CallTerm(op, lhs, rhs).SetLoc(lhs.Location)
+		rhs := p.parseUnary()
+		if rhs == nil {
+			return nil
+		}
+		// the right operand takes the operators that bind tighter than op and,
+		// if op is right associative, those of the same precedence
+		next := op.prec
+		if op.assoc == assocRight {
+			next--
+		}
+		if rhs = p.parseInfix(rhs, next); rhs == nil {
+			return nil
+		}
 
-				tok = p.token()
-				if tok == tokens.Multiply || tok == tokens.Divide || tok == tokens.Modulus {
-					return p.parseTermRelation(call)
-				}
-				return call
-			}
-		} else if tok == tokens.Add || tok == tokens.Subtract {
-			p.nextNonSpace()
-			if rhs := p.parseTermRelation(nil); rhs != nil {
-				op := term.OpTerm(tok.String()).SetLoc(loc)
-				call := term.CallTerm(op, lhs, rhs).SetLoc(lhs.Location)
+		lhs = term.CallTerm(term.OpTerm(tok.String()).SetLoc(loc), lhs, rhs).SetLoc(p.span(lhs.Location))
+	}
+}
 
-				tok = p.token()
-				if tok == tokens.Add || tok == tokens.Subtract {
-					return p.parseTermRelation(call)
-				}
-				return call
-			}
-		} else if tok == tokens.And {
-			p.nextNonSpace()
-			if rhs := p.parseTermRelation(nil); rhs != nil {
-				op := term.OpTerm(tok.String()).SetLoc(loc)
-				call := term.CallTerm(op, lhs, rhs).SetLoc(lhs.Location)
+type prefixOperator struct {
+	name string // name of the operator term
+	prec int
+}
 
-				tok = p.token()
-				if tok == tokens.And {
-					return p.parseTermRelation(call)
-				}
-				return call
-			}
-		} else if tok == tokens.Or {
-			p.nextNonSpace()
-			if rhs := p.parseTermRelation(nil); rhs != nil {
-				op := term.OpTerm(tok.String()).SetLoc(loc)
-				call := term.CallTerm(op, lhs, rhs).SetLoc(lhs.Location)
+// prefixOperators holds the operator and precedence of each prefix operator.
+// Prefix operators are right associative, e.g. --x is -(-x).
+var prefixOperators = map[tokens.Token]prefixOperator{
+	tokens.Subtract: {name: "negate", prec: precUnary}, // -x
+	tokens.Bang:     {name: "not", prec: precUnary},    // !x
+}
 
-				tok = p.token()
-				if tok == tokens.Or {
-					return p.parseTermRelation(call)
-				}
-				return call
-			}
-		} else if tok == tokens.Equal || tok == tokens.NEqual || tok == tokens.LT || tok == tokens.GT || tok == tokens.LTE || tok == tokens.GTE {
-			p.nextNonSpace()
-			if rhs := p.parseTermRelation(nil); rhs != nil {
-				op := term.OpTerm(tok.String()).SetLoc(loc)
-				call := term.CallTerm(op, lhs, rhs).SetLoc(lhs.Location)
+// parseUnary parses an operand of an infix operator, which may be preceded by
+// any number of prefix operators. A minus sign directly before a number is
+// part of the number, e.g. -1.
+func (p *parser) parseUnary() *term.Term {
+	op, ok := prefixOperators[p.token()]
+	if !ok {
+		return p.parseTerm()
+	}
+	tok := p.token()
+	loc := p.loc()
+	p.nextNonSpace()
 
-				tok = p.token()
-				if tok == tokens.Equal || tok == tokens.NEqual || tok == tokens.LT || tok == tokens.GT || tok == tokens.LTE || tok == tokens.GTE {
-					return p.parseTermRelation(call)
-				}
-				return call
-			}
+	if tok == tokens.Subtract && p.token() == tokens.Number {
+		t := p.parseNumber()
+		if t == nil {
+			return nil
 		}
+		t.Value = "-" + t.Value.(term.Number)
+		return t.SetLoc(p.span(loc))
 	}
 
-	return lhs
+	operand := p.parseUnary()
+	if operand == nil {
+		return nil
+	}
+	if operand = p.parseInfix(operand, op.prec); operand == nil {
+		return nil
+	}
+	return term.CallTerm(term.OpTerm(op.name).SetLoc(loc), operand).SetLoc(p.span(loc))
 }
 
 func (p *parser) errorf(l *term.Location, f string, a ...interface{}) {
+	if p.tooManyErrors() {
+		return
+	}
 	p.errors = append(p.errors, &ast.Error{
 		Message:  fmt.Sprintf(f, a...),
 		Location: l,
@@ -457,6 +1218,29 @@ Loop:
 	return p.token()
 }
 
+// lineBreak returns true if an end of line precedes the current token, only
+// white space may follow it.
+func (p *parser) lineBreak() bool {
+	for i := p.index - 1; i >= 0; i-- {
+		switch p.items[i].Token {
+		case tokens.EOL:
+			return true
+		case tokens.Whitespace:
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// skipSpace advances past any whitespace or end of line at the current position.
+func (p *parser) skipSpace() tokens.Token {
+	if tok := p.token(); tok == tokens.Whitespace || tok == tokens.EOL {
+		return p.nextNonSpace()
+	}
+	return p.token()
+}
+
 func (p *parser) next() tokens.Token {
 	p.index++
 	return p.token()
@@ -469,15 +1253,54 @@ func (p *parser) token() tokens.Token {
 	return tokens.EOF
 }
 
+// loc returns the location of the current token.
 func (p *parser) loc() *term.Location {
 	if p.index < len(p.items) {
-		return &term.Location{
-			File:   p.file,
-			Line:   p.items[p.index].Pos.Line,
-			Column: p.items[p.index].Pos.Column,
-		}
+		return p.location(p.items[p.index].Pos, p.items[p.index].End)
 	}
 	return &term.Location{
 		File: p.file,
 	}
 }
+
+// span returns the location from the start of loc up to the end of the last
+// token consumed, trailing white space and ends of lines are not included.
+func (p *parser) span(loc *term.Location) *term.Location {
+	if loc == nil {
+		return nil
+	}
+	start := lexer.Pos{Index: loc.Offset, Line: loc.Line, Column: loc.Column}
+
+	i := p.index - 1
+	if i >= len(p.items) {
+		i = len(p.items) - 1
+	}
+	for ; i >= 0; i-- {
+		if tok := p.items[i].Token; tok != tokens.Whitespace && tok != tokens.EOL {
+			break
+		}
+	}
+	if i < 0 || p.items[i].End.Index < start.Index {
+		cpy := *loc
+		return &cpy
+	}
+	return p.location(start, p.items[i].End)
+}
+
+// location returns the location of the input from start up to end. The Text
+// of every location is a slice of the same copy of the input.
+func (p *parser) location(start, end lexer.Pos) *term.Location {
+	loc := &term.Location{
+		File:      p.file,
+		Line:      start.Line,
+		Column:    start.Column,
+		Offset:    start.Index,
+		EndLine:   end.Line,
+		EndColumn: end.Column,
+		EndOffset: end.Index,
+	}
+	if end.Index <= len(p.source) { // the input is unknown if only items were given
+		loc.Text = p.source[start.Index:end.Index:end.Index]
+	}
+	return loc
+}
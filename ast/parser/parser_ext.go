@@ -7,6 +7,13 @@ import (
 	"avidbound.com/zego/ast/term"
 )
 
+// ParserOptions configures the parser.
+type ParserOptions struct {
+	// MaxErrors is the number of errors after which parsing stops. Defaults to
+	// DefaultMaxErrors, there is no limit if it is negative.
+	MaxErrors int
+}
+
 func ParseQuery(input string) (ast.Body, error) {
 	body, errs := NewParser("", input).parseQuery()
 
@@ -21,11 +28,17 @@ func ParseQuery(input string) (ast.Body, error) {
 // For details on Module objects and their fields, see policy.go.
 // Empty input will return nil, nil.
 func ParseModule(filename, input string) (*ast.Module, error) {
-	stmts, err := ParseStatements(filename, input)
+	return ParseModuleWithOpts(filename, input, ParserOptions{})
+}
+
+// ParseModuleWithOpts is like ParseModule, the parser is configured by opts.
+func ParseModuleWithOpts(filename, input string, opts ParserOptions) (*ast.Module, error) {
+	p := newParserWithOpts(filename, input, opts)
+	stmts, err := p.parse()
 	if err != nil {
 		return nil, err
 	}
-	return parseModule(filename, stmts)
+	return parseModule(filename, stmts, p.comments)
 }
 
 func ParseStatement(input string) (ast.Statement, error) {
@@ -39,11 +52,20 @@ func ParseStatement(input string) (ast.Statement, error) {
 	return stmts[0], nil
 }
 
+// ParseStatements returns the statements of the input. Statements that fail to
+// parse are skipped, the errors of every statement are returned at once up to
+// DefaultMaxErrors.
 func ParseStatements(name, input string) ([]ast.Statement, error) {
-	return NewParser(name, input).parse()
+	return ParseStatementsWithOpts(name, input, ParserOptions{})
+}
+
+// ParseStatementsWithOpts is like ParseStatements, the parser is configured by
+// opts.
+func ParseStatementsWithOpts(name, input string, opts ParserOptions) ([]ast.Statement, error) {
+	return newParserWithOpts(name, input, opts).parse()
 }
 
-func parseModule(filename string, stmts []ast.Statement) (*ast.Module, error) {
+func parseModule(filename string, stmts []ast.Statement, comments []*ast.Comment) (*ast.Module, error) {
 
 	if len(stmts) == 0 {
 		return nil, ast.NewError(&term.Location{File: filename}, "empty module")
@@ -58,13 +80,22 @@ func parseModule(filename string, stmts []ast.Statement) (*ast.Module, error) {
 	}
 
 	mod := &ast.Module{
-		Package: pkg,
+		Package:  pkg,
+		Comments: comments,
 	}
 
 	for _, stmt := range stmts[1:] {
 		switch stmt := stmt.(type) {
+		case *ast.Import:
+			if len(mod.Rules) > 0 {
+				errs = append(errs, ast.NewError(stmt.Loc(), "import must precede rules"))
+				continue
+			}
+			mod.Imports = append(mod.Imports, stmt)
 		case *ast.Rule:
-			stmt.Module = mod
+			for rule := stmt; rule != nil; rule = rule.Else {
+				rule.Module = mod
+			}
 			mod.Rules = append(mod.Rules, stmt)
 		case *ast.Package:
 			errs = append(errs, ast.NewError(stmt.Loc(), "unexpected package"))
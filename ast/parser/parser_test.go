@@ -1,10 +1,13 @@
 package parser
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"avidbound.com/zego/ast"
 	"avidbound.com/zego/ast/internal/lexer"
+	"avidbound.com/zego/ast/internal/tokens"
 	"avidbound.com/zego/ast/term"
 )
 
@@ -27,12 +30,675 @@ func TestParseTermRelation(t *testing.T) {
 				term.VarTerm("e"))))
 }
 
+func TestPrecedence(t *testing.T) {
+	a, b, c := term.VarTerm("a"), term.VarTerm("b"), term.VarTerm("c")
+	call := func(op string, lhs, rhs *term.Term) *term.Term {
+		return term.CallTerm(term.OpTerm(op), lhs, rhs)
+	}
+
+	assertParseTermRelation(t, "subtraction left associative", `a - b - c`,
+		call("minus", call("minus", a, b), c))
+	assertParseTermRelation(t, "division left associative", `a / b / c`,
+		call("divide", call("divide", a, b), c))
+	assertParseTermRelation(t, "multiplication before addition", `a * b + c`,
+		call("add", call("multiply", a, b), c))
+	assertParseTermRelation(t, "addition after multiplication", `a + b * c`,
+		call("add", a, call("multiply", b, c)))
+	assertParseTermRelation(t, "modulus before subtraction", `a - b % c`,
+		call("minus", a, call("modulus", b, c)))
+	assertParseTermRelation(t, "arithmetic before comparison", `a + b < c`,
+		call("lt", call("add", a, b), c))
+	assertParseTermRelation(t, "arithmetic before membership", `1 + 1 in [2]`,
+		call("in", call("add", term.NumberTerm("1"), term.NumberTerm("1")), term.ArrayTerm(term.NumberTerm("2"))))
+	assertParseTermRelation(t, "intersection before union", `a | b & c`,
+		call("or", a, call("and", b, c)))
+	assertParseTermRelation(t, "union before comparison", `a | b == c`,
+		call("equal", call("or", a, b), c))
+	assertParseTermRelation(t, "comparison left associative", `a == b != c`,
+		call("nEqual", call("equal", a, b), c))
+	assertParseTermRelation(t, "parentheses", `a * (b + c)`,
+		call("multiply", a, call("add", b, c)))
+	assertParseTermRelation(t, "mixed", `a * b - c / a + b`,
+		call("add", call("minus", call("multiply", a, b), call("divide", c, a)), b))
+
+	// the associativity is read from the table
+	sub := infixOperators[tokens.Subtract]
+	infixOperators[tokens.Subtract] = infixOperator{sub.prec, assocRight}
+	defer func() { infixOperators[tokens.Subtract] = sub }()
+	assertParseTermRelation(t, "right associative", `a - b - c`,
+		call("minus", a, call("minus", b, c)))
+}
+
+func TestLineBreak(t *testing.T) {
+	x := term.VarTerm("x")
+	call := func(op string, operands ...*term.Term) *term.Term {
+		return term.CallTerm(append([]*term.Term{term.OpTerm(op)}, operands...)...)
+	}
+	rule := func(body ...*ast.Expr) *ast.Rule {
+		return &ast.Rule{Name: term.Var("a"), Value: term.NumberTerm("1"), Body: ast.NewBody(body...)}
+	}
+
+	assertParseRule(t, "operator on next line", "a := 1 {\n\tx := 2\n\t- 1 < x\n}", rule(
+		ast.NewExpr(call("declare", x, term.NumberTerm("2"))),
+		ast.NewExpr(call("lt", term.NumberTerm("-1"), x)),
+	))
+	assertParseRule(t, "operator at end of line", "a := 1 {\n\tx := 2 -\n\t\t1\n}", rule(
+		ast.NewExpr(call("declare", x, call("minus", term.NumberTerm("2"), term.NumberTerm("1")))),
+	))
+	assertParseRule(t, "operator inside parentheses", "a := 1 {\n\tx := (2\n\t\t- 1)\n}", rule(
+		ast.NewExpr(call("declare", x, call("minus", term.NumberTerm("2"), term.NumberTerm("1")))),
+	))
+	assertParseRule(t, "operator inside array", "a := 1 {\n\tx := [2\n\t\t- 1]\n}", rule(
+		ast.NewExpr(call("declare", x, term.ArrayTerm(call("minus", term.NumberTerm("2"), term.NumberTerm("1"))))),
+	))
+	assertParseRule(t, "operator inside comprehension body", "a := 1 {\n\tx := [y | y := 2\n\t\t- 1 < y]\n}", rule(
+		ast.NewExpr(call("declare", x, ast.ArrayComprehensionTerm(term.VarTerm("y"), ast.NewBody(
+			ast.NewExpr(call("declare", term.VarTerm("y"), term.NumberTerm("2"))),
+			ast.NewExpr(call("lt", term.NumberTerm("-1"), term.VarTerm("y"))),
+		)))),
+	))
+}
+
+func TestPrefixOperators(t *testing.T) {
+	a, b := term.VarTerm("a"), term.VarTerm("b")
+	delta := term.RefTerm(term.VarTerm("input"), term.StringTerm("delta"))
+	call := func(op string, operands ...*term.Term) *term.Term {
+		return term.CallTerm(append([]*term.Term{term.OpTerm(op)}, operands...)...)
+	}
+
+	assertParseTermRelation(t, "negative literal", `-1`, term.NumberTerm("-1"))
+	assertParseTermRelation(t, "negative float", `-1.5`, term.NumberTerm("-1.5"))
+	assertParseTermRelation(t, "negative element", `[1, -2]`, term.ArrayTerm(term.NumberTerm("1"), term.NumberTerm("-2")))
+	assertParseTermRelation(t, "subtract literal", `a - 1`, call("minus", a, term.NumberTerm("1")))
+	assertParseTermRelation(t, "subtract negative", `a - -1`, call("minus", a, term.NumberTerm("-1")))
+	assertParseTermRelation(t, "negate ref", `-input.delta`, call("negate", delta))
+	assertParseTermRelation(t, "negate group", `-(a + b)`, call("negate", call("add", a, b)))
+	assertParseTermRelation(t, "negate before multiply", `-a * b`, call("multiply", call("negate", a), b))
+	assertParseTermRelation(t, "double negate", `--a`, call("negate", call("negate", a)))
+	assertParseTermRelation(t, "not", `!a`, call("not", a))
+	assertParseTermRelation(t, "not before comparison", `!a == b`, call("equal", call("not", a), b))
+	assertParseTermRelation(t, "not group", `!(a == b)`, call("not", call("equal", a, b)))
+
+	assertParseError(t, "missing operand", `a := -`)
+
+	x := term.VarTerm("x")
+	f := term.RefTerm(term.VarTerm("f"))
+	assertParseRule(t, "line starts with prefix operator", "a := 1 {\n\tx := 2\n\t-1 < x\n\t-x < 0\n\t-f(x) < 0\n}", &ast.Rule{
+		Name:  term.Var("a"),
+		Value: term.NumberTerm("1"),
+		Body: ast.NewBody(
+			ast.NewExpr(call("declare", x, term.NumberTerm("2"))),
+			ast.NewExpr(call("lt", term.NumberTerm("-1"), x)),
+			ast.NewExpr(call("lt", call("negate", x), term.NumberTerm("0"))),
+			ast.NewExpr(call("lt", call("negate", term.CallTerm(f, x)), term.NumberTerm("0"))),
+		),
+	})
+}
+
+func TestRawString(t *testing.T) {
+	input := "test := `^[a-z]+\\d$` {\n\tinput.doc == `{\n\t\t\"a\": 1\n\t}` }"
+
+	stmt, err := ParseStatement(input)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	rule := stmt.(*ast.Rule)
+
+	expected := term.StringTerm(`^[a-z]+\d$`)
+	if !rule.Value.Value.Equal(expected.Value) {
+		t.Errorf("expected value %v but got %v", expected, rule.Value)
+	}
+	assertTermLocation(t, "raw string value", rule.Value, 1, 9)
+
+	call := rule.Body[0].Terms.(*term.Term).Value.(term.Call)
+	expected = term.StringTerm("{\n\t\t\"a\": 1\n\t}")
+	if !call[2].Value.Equal(expected.Value) {
+		t.Errorf("expected value %v but got %v", expected, call[2])
+	}
+	assertTermLocation(t, "multi-line raw string", call[2], 2, 15)
+
+	if len(rule.Body) != 1 {
+		t.Errorf("expected 1 expression but got %d", len(rule.Body))
+	}
+}
+
+func TestArray(t *testing.T) {
+	assertParseTermRelation(t, "empty", `[]`, term.ArrayTerm())
+	assertParseTermRelation(t, "scalars", `[1, "a", true, null]`,
+		term.ArrayTerm(term.NumberTerm("1"), term.StringTerm("a"), term.BooleanTerm(true), term.NullTerm()))
+	assertParseTermRelation(t, "nested", `[[1], input.x, a + 1]`,
+		term.ArrayTerm(
+			term.ArrayTerm(term.NumberTerm("1")),
+			term.RefTerm(term.VarTerm("input"), term.StringTerm("x")),
+			term.CallTerm(term.OpTerm("add"), term.VarTerm("a"), term.NumberTerm("1"))))
+	assertParseTermRelation(t, "multiple lines", "[\n\t1,\n\t2,\n]",
+		term.ArrayTerm(term.NumberTerm("1"), term.NumberTerm("2")))
+	assertParseTermRelation(t, "index", `[1, 2][0]`,
+		term.RefTerm(term.ArrayTerm(term.NumberTerm("1"), term.NumberTerm("2")), term.NumberTerm("0")))
+	assertParseTermRelation(t, "comparison", `[1] == x`,
+		term.CallTerm(term.OpTerm("equal"), term.ArrayTerm(term.NumberTerm("1")), term.VarTerm("x")))
+}
+
+func TestObject(t *testing.T) {
+	assertParseTermRelation(t, "empty", `{}`, term.ObjectTerm())
+	assertParseTermRelation(t, "scalars", `{"role": "admin", "level": 1}`,
+		term.ObjectTerm(
+			term.Item(term.StringTerm("role"), term.StringTerm("admin")),
+			term.Item(term.StringTerm("level"), term.NumberTerm("1"))))
+	assertParseTermRelation(t, "nested", "{\n\t\"a\": {\"b\": [x]},\n\tk: input.v,\n}",
+		term.ObjectTerm(
+			term.Item(term.StringTerm("a"), term.ObjectTerm(term.Item(term.StringTerm("b"), term.ArrayTerm(term.VarTerm("x"))))),
+			term.Item(term.VarTerm("k"), term.RefTerm(term.VarTerm("input"), term.StringTerm("v")))))
+	assertParseTermRelation(t, "index", `{"a": 1}.a`,
+		term.RefTerm(term.ObjectTerm(term.Item(term.StringTerm("a"), term.NumberTerm("1"))), term.StringTerm("a")))
+
+	assertParseRule(t, "object value",
+		`test := {"a": 1}`,
+		&ast.Rule{
+			Name:  term.Var("test"),
+			Value: term.ObjectTerm(term.Item(term.StringTerm("a"), term.NumberTerm("1"))),
+		})
+
+	assertParseRule(t, "object value with body",
+		`test := {"a": x} {
+			x := {}
+		}`,
+		&ast.Rule{
+			Name:  term.Var("test"),
+			Value: term.ObjectTerm(term.Item(term.StringTerm("a"), term.VarTerm("x"))),
+			Body: ast.NewBody(
+				ast.NewExpr(term.CallTerm(term.OpTerm("declare"), term.VarTerm("x"), term.ObjectTerm())),
+			),
+		})
+
+	assertParseError(t, "duplicate key", `test := {"a": 1, "a": 2}`)
+	assertParseError(t, "missing colon", `test := {"a" 1}`)
+}
+
+func TestSet(t *testing.T) {
+	assertParseTermRelation(t, "empty", `set()`, term.SetTerm())
+	assertParseTermRelation(t, "single", `{1}`, term.SetTerm(term.NumberTerm("1")))
+	assertParseTermRelation(t, "elements", `{"a", x, input.y, "a"}`,
+		term.SetTerm(term.StringTerm("a"), term.VarTerm("x"), term.RefTerm(term.VarTerm("input"), term.StringTerm("y"))))
+	assertParseTermRelation(t, "intersection", `a & {1}`,
+		term.CallTerm(term.OpTerm("and"), term.VarTerm("a"), term.SetTerm(term.NumberTerm("1"))))
+	assertParseTermRelation(t, "union", `a | set()`,
+		term.CallTerm(term.OpTerm("or"), term.VarTerm("a"), term.SetTerm()))
+	assertParseError(t, "missing separator", `test := {1 2}`)
+}
+
+func TestComprehension(t *testing.T) {
+	items := term.RefTerm(term.VarTerm("input"), term.StringTerm("items"), term.VarTerm("_"))
+	declare := ast.NewExpr(term.CallTerm(term.OpTerm("declare"), term.VarTerm("x"), items))
+
+	assertParseTermRelation(t, "array", `[x | x := input.items[_]; x.enabled]`,
+		ast.ArrayComprehensionTerm(term.VarTerm("x"), ast.NewBody(
+			declare,
+			ast.NewExpr(term.RefTerm(term.VarTerm("x"), term.StringTerm("enabled"))),
+		)))
+	assertParseTermRelation(t, "set", `{x | x := input.items[_]}`,
+		ast.SetComprehensionTerm(term.VarTerm("x"), ast.NewBody(declare)))
+	assertParseTermRelation(t, "object", `{k: x | x := input.items[k]}`,
+		ast.ObjectComprehensionTerm(term.VarTerm("k"), term.VarTerm("x"), ast.NewBody(
+			ast.NewExpr(term.CallTerm(term.OpTerm("declare"), term.VarTerm("x"),
+				term.RefTerm(term.VarTerm("input"), term.StringTerm("items"), term.VarTerm("k")))),
+		)))
+	assertParseTermRelation(t, "multiline", `[x |
+		x := input.items[_]
+	]`,
+		ast.ArrayComprehensionTerm(term.VarTerm("x"), ast.NewBody(declare)))
+	assertParseTermRelation(t, "head expression", `[x + 1 | x := input.items[_]]`,
+		ast.ArrayComprehensionTerm(term.CallTerm(term.OpTerm("add"), term.VarTerm("x"), term.NumberTerm("1")), ast.NewBody(declare)))
+	assertParseTermRelation(t, "union in head", `{(a | b) | x := input.items[_]}`,
+		ast.SetComprehensionTerm(term.CallTerm(term.OpTerm("or"), term.VarTerm("a"), term.VarTerm("b")), ast.NewBody(declare)))
+	assertParseTermRelation(t, "union in element", `[1, a | b]`,
+		term.ArrayTerm(term.NumberTerm("1"), term.CallTerm(term.OpTerm("or"), term.VarTerm("a"), term.VarTerm("b"))))
+	assertParseTermRelation(t, "index", `[x | x := input.items[_]][0]`,
+		term.RefTerm(ast.ArrayComprehensionTerm(term.VarTerm("x"), ast.NewBody(declare)), term.NumberTerm("0")))
+	assertParseError(t, "missing body", `test := [x | ]`)
+}
+
+func TestNot(t *testing.T) {
+	negated := ast.NewExpr(term.RefTerm(term.VarTerm("input"), term.StringTerm("blocked")))
+	negated.Negated = true
+
+	assertParseRule(t, "negated",
+		`allow := true {
+			not input.blocked
+			input.user == "admin"
+		}`,
+		&ast.Rule{
+			Name:  term.Var("allow"),
+			Value: term.BooleanTerm(true),
+			Body: ast.NewBody(
+				negated,
+				ast.NewExpr(term.CallTerm(term.OpTerm("equal"), term.RefTerm(term.VarTerm("input"), term.StringTerm("user")), term.StringTerm("admin"))),
+			),
+		})
+
+	if expr := ast.NewExpr(term.RefTerm(term.VarTerm("input"), term.StringTerm("blocked"))); expr.Compare(negated) >= 0 {
+		t.Errorf("expected non-negated expression to be less than negated expression")
+	}
+
+	single := ast.NewExpr(term.BooleanTerm(true))
+	single.Index = 1
+	builtin := ast.NewExpr([]*term.Term{term.OpTerm("equal"), term.VarTerm("x"), term.BooleanTerm(true)})
+	if single.Compare(builtin) >= 0 {
+		t.Errorf("expected single term expression to be less than built-in expression regardless of index")
+	}
+
+	later := ast.NewExpr(term.BooleanTerm(true))
+	later.Index = 1
+	if negated.Compare(later) <= 0 {
+		t.Errorf("expected negated expression to be greater than non-negated expression regardless of index")
+	}
+
+	assertParseError(t, "declaration", `a := true { not x := 1 }`)
+	assertParseError(t, "double negation", `a := true { not not input.x }`)
+	assertParseError(t, "missing expression", `a := true { not }`)
+}
+
+func TestSome(t *testing.T) {
+	items := term.RefTerm(term.VarTerm("input"), term.StringTerm("items"))
+
+	assertParseRule(t, "value",
+		`a := x { some x in input.items }`,
+		&ast.Rule{
+			Name:  term.Var("a"),
+			Value: term.VarTerm("x"),
+			Body:  ast.NewBody(ast.NewExpr(&ast.SomeDecl{Value: term.VarTerm("x"), Collection: items})),
+		})
+
+	assertParseRule(t, "key value",
+		`a := k { some k, v in input.items; v == 1 }`,
+		&ast.Rule{
+			Name:  term.Var("a"),
+			Value: term.VarTerm("k"),
+			Body: ast.NewBody(
+				ast.NewExpr(&ast.SomeDecl{Key: term.VarTerm("k"), Value: term.VarTerm("v"), Collection: items}),
+				ast.NewExpr(term.CallTerm(term.OpTerm("equal"), term.VarTerm("v"), term.NumberTerm("1"))),
+			),
+		})
+
+	assertParseTermRelation(t, "membership", `x in input.items`,
+		term.CallTerm(term.OpTerm("in"), term.VarTerm("x"), items))
+	assertParseTermRelation(t, "membership literal", `"a" in {"a", "b"}`,
+		term.CallTerm(term.OpTerm("in"), term.StringTerm("a"), term.SetTerm(term.StringTerm("a"), term.StringTerm("b"))))
+
+	assertParseError(t, "missing in", `a := true { some x }`)
+	assertParseError(t, "non-variable", `a := true { some [x] in input.items }`)
+	assertParseError(t, "negated", `a := true { not some x in input.items }`)
+}
+
+func TestEvery(t *testing.T) {
+	items := term.RefTerm(term.VarTerm("input"), term.StringTerm("items"))
+
+	assertParseRule(t, "value",
+		`a := true { every x in input.items { x != 0 } }`,
+		&ast.Rule{
+			Name:  term.Var("a"),
+			Value: term.BooleanTerm(true),
+			Body: ast.NewBody(ast.NewExpr(&ast.Every{
+				Value:      term.VarTerm("x"),
+				Collection: items,
+				Body:       ast.NewBody(ast.NewExpr(term.CallTerm(term.OpTerm("nEqual"), term.VarTerm("x"), term.NumberTerm("0")))),
+			})),
+		})
+
+	assertParseRule(t, "key value",
+		`a := true { every k, v in input.items { k; v } }`,
+		&ast.Rule{
+			Name:  term.Var("a"),
+			Value: term.BooleanTerm(true),
+			Body: ast.NewBody(ast.NewExpr(&ast.Every{
+				Key:        term.VarTerm("k"),
+				Value:      term.VarTerm("v"),
+				Collection: items,
+				Body:       ast.NewBody(ast.NewExpr(term.VarTerm("k")), ast.NewExpr(term.VarTerm("v"))),
+			})),
+		})
+
+	assertParseError(t, "missing body", `a := true { every x in input.items }`)
+	assertParseError(t, "empty body", `a := true { every x in input.items {} }`)
+	assertParseError(t, "missing in", `a := true { every x { true } }`)
+	assertParseError(t, "negated", `a := true { not every x in input.items { x } }`)
+}
+
+func TestUnify(t *testing.T) {
+	unify := term.OpTerm("unify")
+	pair := term.RefTerm(term.VarTerm("input"), term.StringTerm("pair"))
+
+	assertParseRule(t, "array",
+		`a := x { [x, y] = input.pair }`,
+		&ast.Rule{
+			Name:  term.Var("a"),
+			Value: term.VarTerm("x"),
+			Body:  ast.NewBody(ast.NewExpr(term.CallTerm(unify, term.ArrayTerm(term.VarTerm("x"), term.VarTerm("y")), pair))),
+		})
+
+	assertParseRule(t, "negated",
+		`a := true { not input.pair = [1, 2] }`,
+		&ast.Rule{
+			Name:  term.Var("a"),
+			Value: term.BooleanTerm(true),
+			Body: ast.NewBody(&ast.Expr{
+				Negated: true,
+				Terms:   term.CallTerm(unify, pair, term.ArrayTerm(term.NumberTerm("1"), term.NumberTerm("2"))),
+			}),
+		})
+
+	assertParseError(t, "missing operand", `a := true { x = }`)
+}
+
+func TestWith(t *testing.T) {
+	user := term.RefTerm(term.VarTerm("input"), term.StringTerm("user"))
+	now := term.RefTerm(term.VarTerm("time"), term.StringTerm("now_ns"))
+
+	assertParseRule(t, "input",
+		`a := true { allow with input.user as {"role": "admin"} }`,
+		&ast.Rule{
+			Name:  term.Var("a"),
+			Value: term.BooleanTerm(true),
+			Body: ast.NewBody(&ast.Expr{
+				Terms: term.VarTerm("allow"),
+				With: []*ast.With{
+					{Target: user, Value: term.ObjectTerm([2]*term.Term{term.StringTerm("role"), term.StringTerm("admin")})},
+				},
+			}),
+		})
+
+	assertParseRule(t, "multiple",
+		`a := true { not allow with input.user as 1 with time.now_ns as mock }`,
+		&ast.Rule{
+			Name:  term.Var("a"),
+			Value: term.BooleanTerm(true),
+			Body: ast.NewBody(&ast.Expr{
+				Negated: true,
+				Terms:   term.VarTerm("allow"),
+				With: []*ast.With{
+					{Target: user, Value: term.NumberTerm("1")},
+					{Target: now, Value: term.VarTerm("mock")},
+				},
+			}),
+		})
+
+	assertParseError(t, "missing as", `a := true { allow with input.user }`)
+	assertParseError(t, "invalid target", `a := true { allow with input[x] as 1 }`)
+	assertParseError(t, "literal target", `a := true { allow with 1 as 1 }`)
+}
+
+func TestErrorRecovery(t *testing.T) {
+	module := `package test
+
+	a := 1 + * 2
+	b := true { x := [1, 2; x }
+	c := 1
+	d := true {
+		e := 1
+		f(x) := }
+	g := $
+	h := {"a": 1}}
+	i := 2`
+
+	assertParseErrors(t, "statements", module, ParserOptions{}, []int{3, 4, 8, 9, 10})
+	assertParseErrors(t, "limit", module, ParserOptions{MaxErrors: 2}, []int{3, 4})
+	assertParseErrors(t, "no limit", module, ParserOptions{MaxErrors: -1}, []int{3, 4, 8, 9, 10})
+	assertParseErrors(t, "lexer", "package test\na := \"x\nb := @ + 1 ~\nc := 1", ParserOptions{}, []int{2, 3, 3})
+	assertParseErrors(t, "unexpected token", "package test\n]\na := 1\n) b := 2\nc := 1 + +", ParserOptions{}, []int{2, 4, 5})
+}
+
+func TestLocation(t *testing.T) {
+	module := "package a.b\n\np := [1, 2][0] + f(x)\n\nq := -3 {\n\tx := {\"a\": [y | y := input.z[_]]}\n\tnot x.a == 1 with input as 2\n} else := 2"
+
+	mod, err := ParseModule("test.zego", module)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	assertLocation(t, "package", mod.Package.Location, 1, 1, 1, 12, "package a.b")
+
+	p := mod.Rules[0]
+	assertLocation(t, "rule", p.Location, 3, 1, 3, 22, "p := [1, 2][0] + f(x)")
+	assertLocation(t, "infix", p.Value.Location, 3, 6, 3, 22, "[1, 2][0] + f(x)")
+	call := p.Value.Value.(term.Call)
+	assertLocation(t, "operator", call[0].Location, 3, 16, 3, 17, "+")
+	assertLocation(t, "ref", call[1].Location, 3, 6, 3, 15, "[1, 2][0]")
+	assertLocation(t, "array", call[1].Value.(term.Ref)[0].Location, 3, 6, 3, 12, "[1, 2]")
+	assertLocation(t, "call", call[2].Location, 3, 18, 3, 22, "f(x)")
+
+	q := mod.Rules[1]
+	assertLocation(t, "rule with else", q.Location, 5, 1, 8, 12, "q := -3 {\n\tx := {\"a\": [y | y := input.z[_]]}\n\tnot x.a == 1 with input as 2\n} else := 2")
+	assertLocation(t, "negative number", q.Value.Location, 5, 6, 5, 8, "-3")
+	assertLocation(t, "else", q.Else.Location, 8, 3, 8, 12, "else := 2")
+	assertLocation(t, "declaration", q.Body[0].Location, 6, 2, 6, 35, "x := {\"a\": [y | y := input.z[_]]}")
+	object := q.Body[0].Terms.(*term.Term).Value.(term.Call)[2]
+	assertLocation(t, "object", object.Location, 6, 7, 6, 35, "{\"a\": [y | y := input.z[_]]}")
+	comprehension := object.Value.(term.Object)[0][1]
+	assertLocation(t, "comprehension", comprehension.Location, 6, 13, 6, 34, "[y | y := input.z[_]]")
+	assertLocation(t, "comprehension body", comprehension.Value.(*ast.ArrayComprehension).Body[0].Location, 6, 18, 6, 33, "y := input.z[_]")
+	assertLocation(t, "negated expression", q.Body[1].Location, 7, 2, 7, 30, "not x.a == 1 with input as 2")
+	assertLocation(t, "with", q.Body[1].With[0].Location, 7, 15, 7, 30, "with input as 2")
+
+	offset := strings.Index(module, "f(x)")
+	if loc := call[2].Location; loc.Offset != offset || loc.EndOffset != offset+len("f(x)") {
+		t.Errorf("expected offsets %d to %d but got %d to %d", offset, offset+len("f(x)"), loc.Offset, loc.EndOffset)
+	}
+}
+
+func TestComments(t *testing.T) {
+	module := `# the package
+package test
+
+# multi-line
+# comment
+p := 1 # trailing
+
+q := [x |
+	# inside
+	x := input.items[_] # after
+]`
+
+	mod, err := ParseModule("test.zego", module)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	expected := []struct {
+		text         string
+		line, column int
+	}{
+		{" the package", 1, 1},
+		{" multi-line", 4, 1},
+		{" comment", 5, 1},
+		{" trailing", 6, 8},
+		{" inside", 9, 2},
+		{" after", 10, 22},
+	}
+	if len(mod.Comments) != len(expected) {
+		t.Fatalf("expected %d comments but got %d: %v", len(expected), len(mod.Comments), mod.Comments)
+	}
+	for i, c := range mod.Comments {
+		if string(c.Text) != expected[i].text {
+			t.Errorf("comment %d: expected %q but got %q", i, expected[i].text, c.Text)
+		}
+		assertLocation(t, fmt.Sprintf("comment %d", i), c.Location, expected[i].line, expected[i].column, expected[i].line, expected[i].column+len(c.Text)+1, c.String())
+	}
+
+	if len(mod.Rules) != 2 {
+		t.Fatalf("expected 2 rules but got %d", len(mod.Rules))
+	}
+	assertLocation(t, "rule after comments", mod.Rules[0].Location, 6, 1, 6, 7, "p := 1")
+	assertLocation(t, "rule with comments", mod.Rules[1].Location, 8, 1, 11, 2, "q := [x |\n\t# inside\n\tx := input.items[_] # after\n]")
+}
+
+func TestAnnotations(t *testing.T) {
+	module := `# METADATA
+# title: Test
+# description: |
+#   Policies for
+#   the test.
+# scope: subpackages
+package test
+
+# METADATA
+# title: Allow admins
+# authors:
+# - Jane Doe <jane@example.com>
+# - name: Bob
+# - bob@example.com
+# entrypoint: true
+# schemas:
+# - input.user: schema.user
+# - input.request: {type: object}
+# custom:
+#   control: AC-2
+#   tags: [a, b]
+allow := true { input.user.admin }
+
+# a comment that is not metadata
+deny := false`
+
+	mod, err := ParseModule("test.zego", module)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	if len(mod.Package.Annotations) != 1 {
+		t.Fatalf("expected 1 package annotation but got %d", len(mod.Package.Annotations))
+	}
+	pkg := mod.Package.Annotations[0]
+	if pkg.Scope != ast.AnnotationScopeSubpackages || pkg.Title != "Test" || pkg.Description != "Policies for\nthe test.\n" {
+		t.Errorf("unexpected package annotations: %+v", pkg)
+	}
+	assertLocation(t, "package annotations", pkg.Location, 1, 1, 6, 21, module[:strings.Index(module, "\npackage")])
+
+	if len(mod.Rules[0].Annotations) != 1 {
+		t.Fatalf("expected 1 rule annotation but got %d", len(mod.Rules[0].Annotations))
+	}
+	rule := mod.Rules[0].Annotations[0]
+	if rule.Scope != ast.AnnotationScopeRule || rule.Title != "Allow admins" || !rule.Entrypoint {
+		t.Errorf("unexpected rule annotations: %+v", rule)
+	}
+
+	authors := []ast.AuthorAnnotation{{Name: "Jane Doe", Email: "jane@example.com"}, {Name: "Bob"}, {Email: "bob@example.com"}}
+	if len(rule.Authors) != len(authors) {
+		t.Fatalf("expected %d authors but got %d", len(authors), len(rule.Authors))
+	}
+	for i, author := range rule.Authors {
+		if *author != authors[i] {
+			t.Errorf("author %d: expected %+v but got %+v", i, authors[i], *author)
+		}
+	}
+
+	if len(rule.Schemas) != 2 {
+		t.Fatalf("expected 2 schemas but got %d", len(rule.Schemas))
+	}
+	if s := rule.Schemas[0]; s.Path.String() != "input.user" || s.Schema.String() != "schema.user" {
+		t.Errorf("unexpected schema: %v %v", s.Path, s.Schema)
+	}
+	if s := rule.Schemas[1]; s.Path.String() != "input.request" || s.Definition.(map[string]interface{})["type"] != "object" {
+		t.Errorf("unexpected schema: %v %v", s.Path, s.Definition)
+	}
+
+	v, err := rule.Value()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if custom := v.(term.Object).Get(term.StringTerm("custom")); custom == nil || custom.String() != `{"control": "AC-2", "tags": ["a", "b"]}` {
+		t.Errorf("unexpected custom annotations: %v", custom)
+	}
+
+	if len(mod.Rules[1].Annotations) != 0 {
+		t.Errorf("expected no annotations but got %v", mod.Rules[1].Annotations)
+	}
+
+	mod, err = ParseModule("test.zego", `package test
+
+# METADATA
+# custom:
+#   base: &base {level: 1}
+#   copy: *base
+#   codes: {404: missing}
+p := 1`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if v, err = mod.Rules[0].Annotations[0].Value(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if custom := v.(term.Object).Get(term.StringTerm("custom")); custom == nil || custom.String() != `{"base": {"level": 1}, "codes": {"404": "missing"}, "copy": {"level": 1}}` {
+		t.Errorf("unexpected custom annotations: %v", custom)
+	}
+
+	assertParseErrors(t, "metadata", `package test
+
+# METADATA
+# title: x
+import input.y
+
+# METADATA
+# titel: typo
+p := 1
+
+# METADATA
+# scope: package
+q := 2
+
+# METADATA
+# custom:
+#   tags: [a,
+r := 3
+
+# METADATA
+# authors: [1]
+s := 4
+
+# METADATA
+# title: a
+# title: b
+t := 5
+
+# METADATA
+# schemas:
+# - input.users[x]: schema.user
+u := 6
+
+# METADATA
+# title: last`, ParserOptions{}, []int{3, 8, 11, 15, 21, 26, 30, 34})
+}
+
 func TestPackage(t *testing.T) {
 	assertParsePackage(t, "single", `package foo`, modulePackage(1, 1, refTerm(1, 9, stringTerm(1, 9, "foo"))))
 	assertParsePackage(t, "multiple", `package foo.bar`, modulePackage(1, 1, refTerm(1, 9, stringTerm(1, 9, "foo"), stringTerm(1, 12, "bar"))))
 	assertParsePackage(t, "space", `package foo["bar bizz"]`, modulePackage(1, 1, refTerm(1, 9, stringTerm(1, 9, "foo"), stringTerm(1, 13, "bar bizz"))))
 }
 
+func TestImport(t *testing.T) {
+	assertParseImport(t, "package", `import zego.lib.users`, &ast.Import{
+		Path: term.RefTerm(term.VarTerm("zego"), term.StringTerm("lib"), term.StringTerm("users")),
+	})
+	assertParseImport(t, "alias", `import input.request as req`, &ast.Import{
+		Path:  term.RefTerm(term.VarTerm("input"), term.StringTerm("request")),
+		Alias: term.Var("req"),
+	})
+	assertParseImport(t, "root", `import input`, &ast.Import{
+		Path: term.VarTerm("input"),
+	})
+	assertParseError(t, "invalid root", `import foo.bar`)
+	assertParseError(t, "invalid element", `import input.a[x]`)
+	assertParseError(t, "missing alias", `import input.a as`)
+
+	mod, err := ParseModule("test.zego", "package a\nimport input.x as y\nimport zego.b\np := y")
+	if err != nil || len(mod.Imports) != 2 {
+		t.Errorf("expected 2 imports but got %v: %v", mod, err)
+	}
+	_, err = ParseModule("test.zego", "package a\np := y\nimport input.x as y")
+	if errs, ok := err.(ast.Errors); !ok || len(errs) != 1 || !strings.Contains(errs[0].Error(), "test.zego:3: import must precede rules") {
+		t.Errorf("expected import after rule error but got %v", err)
+	}
+}
+
 func TestRule(t *testing.T) {
 	assertParseRule(t, "constant",
 		`test := "abc" {
@@ -53,6 +719,18 @@ func TestRule(t *testing.T) {
 			Value: term.BooleanTerm(false),
 		})
 
+	assertParseRule(t, "null",
+		`test := null {
+			input.a == null
+		}`,
+		&ast.Rule{
+			Name:  term.Var("test"),
+			Value: term.NullTerm(),
+			Body: ast.NewBody(
+				ast.NewExpr(term.CallTerm(term.OpTerm("equal"), term.RefTerm(term.VarTerm("input"), term.StringTerm("a")), term.NullTerm())),
+			),
+		})
+
 	assertParseRule(t, "dynamic",
 		`test := input["a"] {
 			input.b[ 1 ] == 12.34
@@ -81,6 +759,141 @@ func TestRule(t *testing.T) {
 		})
 }
 
+func TestDefault(t *testing.T) {
+	assertParseRule(t, "constant", `default allow := false`, &ast.Rule{
+		Default: true,
+		Name:    term.Var("allow"),
+		Value:   term.BooleanTerm(false),
+	})
+	assertParseRule(t, "composite", `default roles := ["guest"]`, &ast.Rule{
+		Default: true,
+		Name:    term.Var("roles"),
+		Value:   term.ArrayTerm(term.StringTerm("guest")),
+	})
+	assertParseError(t, "body", `default allow := false { true }`)
+	assertParseError(t, "args", `default f(x) := 1`)
+	assertParseError(t, "else", `default allow := false else := true`)
+	assertParseError(t, "name", `default := false`)
+}
+
+func TestPartial(t *testing.T) {
+	assertParseRule(t, "set",
+		`deny contains msg {
+			msg := input.errors[_]
+		}`,
+		&ast.Rule{
+			Name: term.Var("deny"),
+			Key:  term.VarTerm("msg"),
+			Body: ast.NewBody(
+				ast.NewExpr(term.CallTerm(term.OpTerm("declare"), term.VarTerm("msg"), term.RefTerm(term.VarTerm("input"), term.StringTerm("errors"), term.VarTerm("_")))),
+			),
+		})
+
+	assertParseRule(t, "set constant", `deny contains "always"`, &ast.Rule{
+		Name: term.Var("deny"),
+		Key:  term.StringTerm("always"),
+	})
+
+	assertParseRule(t, "object",
+		`headers[k] := v {
+			v := input.h[k]
+		}`,
+		&ast.Rule{
+			Name:  term.Var("headers"),
+			Key:   term.VarTerm("k"),
+			Value: term.VarTerm("v"),
+			Body: ast.NewBody(
+				ast.NewExpr(term.CallTerm(term.OpTerm("declare"), term.VarTerm("v"), term.RefTerm(term.VarTerm("input"), term.StringTerm("h"), term.VarTerm("k")))),
+			),
+		})
+
+	assertParseError(t, "set else", `deny contains "a" { true } else := 1`)
+	assertParseError(t, "object else", `a["b"] := 1 { true } else := 2`)
+	assertParseError(t, "unclosed key", `a["b" := 1`)
+	assertParseError(t, "default", `default a["b"] := 1`)
+}
+
+func TestElse(t *testing.T) {
+	spend := func(op string, n *term.Term) ast.Body {
+		return ast.NewBody(ast.NewExpr(term.CallTerm(term.OpTerm(op), term.RefTerm(term.VarTerm("input"), term.StringTerm("spend")), n)))
+	}
+
+	assertParseRule(t, "chain",
+		`tier := "gold" { input.spend > 1000 } else := "silver" {
+			input.spend > 100
+		}
+		else := "bronze"`,
+		&ast.Rule{
+			Name:  term.Var("tier"),
+			Value: term.StringTerm("gold"),
+			Body:  spend("gt", term.NumberTerm("1000")),
+			Else: &ast.Rule{
+				Name:  term.Var("tier"),
+				Value: term.StringTerm("silver"),
+				Body:  spend("gt", term.NumberTerm("100")),
+				Else: &ast.Rule{
+					Name:  term.Var("tier"),
+					Value: term.StringTerm("bronze"),
+				},
+			},
+		})
+
+	assertParseRule(t, "function",
+		`f(x) := 1 { x > 1 } else := 0`,
+		&ast.Rule{
+			Name:  term.Var("f"),
+			Args:  ast.Args{term.VarTerm("x")},
+			Value: term.NumberTerm("1"),
+			Body:  ast.NewBody(ast.NewExpr(term.CallTerm(term.OpTerm("gt"), term.VarTerm("x"), term.NumberTerm("1")))),
+			Else: &ast.Rule{
+				Name:  term.Var("f"),
+				Args:  ast.Args{term.VarTerm("x")},
+				Value: term.NumberTerm("0"),
+			},
+		})
+
+	assertParseError(t, "missing operator", `a := 1 { x } else { y }`)
+	assertParseError(t, "dangling else", `else := 1`)
+}
+
+func TestFunction(t *testing.T) {
+	assertParseRule(t, "args",
+		`is_admin(user) := true {
+			user.role == "admin"
+		}`,
+		&ast.Rule{
+			Name:  term.Var("is_admin"),
+			Args:  ast.Args{term.VarTerm("user")},
+			Value: term.BooleanTerm(true),
+			Body: ast.NewBody(
+				ast.NewExpr(term.CallTerm(term.OpTerm("equal"), term.RefTerm(term.VarTerm("user"), term.StringTerm("role")), term.StringTerm("admin"))),
+			),
+		})
+
+	assertParseRule(t, "patterns",
+		`f(x, [y, _], "a") := x + y`,
+		&ast.Rule{
+			Name: term.Var("f"),
+			Args: ast.Args{
+				term.VarTerm("x"),
+				term.ArrayTerm(term.VarTerm("y"), term.VarTerm("_")),
+				term.StringTerm("a"),
+			},
+			Value: term.CallTerm(term.OpTerm("add"), term.VarTerm("x"), term.VarTerm("y")),
+		})
+
+	assertParseRule(t, "no args",
+		`f() := 1`,
+		&ast.Rule{
+			Name:  term.Var("f"),
+			Args:  ast.Args{},
+			Value: term.NumberTerm("1"),
+		})
+
+	assertParseError(t, "unclosed args", `f(x := 1`)
+	assertParseError(t, "missing value", `f(x) { x }`)
+}
+
 func assertParseTermRelation(t *testing.T, msg, input string, expected *term.Term) {
 	t.Helper()
 
@@ -91,6 +904,20 @@ func assertParseTermRelation(t *testing.T, msg, input string, expected *term.Ter
 	}
 }
 
+func assertParseImport(t *testing.T, msg string, input string, expected *ast.Import) {
+	t.Helper()
+
+	assertParseOne(t, msg, input, func(parsed interface{}) {
+		imp := parsed.(*ast.Import)
+		if !imp.Equal(expected) {
+			t.Errorf("Error on test \"%s\": imports not equal: %v (parsed), %v (expected)", msg, imp, expected)
+		}
+		if imp.Name() != expected.Name() {
+			t.Errorf("Error on test \"%s\": expected name %v but got %v", msg, expected.Name(), imp.Name())
+		}
+	})
+}
+
 func assertParsePackage(t *testing.T, msg string, input string, expected *ast.Package) {
 	t.Helper()
 
@@ -132,6 +959,33 @@ func assertParseRule(t *testing.T, msg string, input string, expected *ast.Rule)
 	})
 }
 
+func assertParseError(t *testing.T, msg string, input string) {
+	t.Helper()
+
+	if _, err := ParseStatements("", input); err == nil {
+		t.Errorf("Error on test \"%s\": expected parse error on %s", msg, input)
+	}
+}
+
+func assertParseErrors(t *testing.T, msg string, input string, opts ParserOptions, lines []int) {
+	t.Helper()
+
+	_, err := ParseStatementsWithOpts("", input, opts)
+	errs, ok := err.(ast.Errors)
+	if !ok {
+		t.Errorf("Error on test \"%s\": expected parse errors but got %v", msg, err)
+		return
+	}
+
+	var got []int
+	for _, e := range errs {
+		got = append(got, e.(*ast.Error).Location.Line)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(lines) {
+		t.Errorf("Error on test \"%s\": expected errors on lines %v but got %v: %v", msg, lines, got, err)
+	}
+}
+
 func assertParseOne(t *testing.T, msg string, input string, correct func(interface{})) {
 	t.Helper()
 
@@ -155,6 +1009,24 @@ func assertTermLocation(t *testing.T, msg string, trm *term.Term, line, column i
 	return trm
 }
 
+func assertLocation(t *testing.T, msg string, loc *term.Location, line, column, endLine, endColumn int, text string) {
+	t.Helper()
+
+	if loc == nil {
+		t.Errorf("Error on test %s: expected location", msg)
+		return
+	}
+	if loc.Line != line || loc.Column != column || loc.EndLine != endLine || loc.EndColumn != endColumn {
+		t.Errorf("Error on test %s: expected %d:%d to %d:%d got: %d:%d to %d:%d", msg, line, column, endLine, endColumn, loc.Line, loc.Column, loc.EndLine, loc.EndColumn)
+	}
+	if string(loc.Text) != text {
+		t.Errorf("Error on test %s: expected text %q got: %q", msg, text, loc.Text)
+	}
+	if len(loc.Text) != loc.EndOffset-loc.Offset {
+		t.Errorf("Error on test %s: text does not match offsets %d to %d", msg, loc.Offset, loc.EndOffset)
+	}
+}
+
 func modulePackage(line, column int, path *term.Term) *ast.Package {
 	pkg := &ast.Package{
 		Location: &term.Location{Line: line, Column: column},
@@ -31,36 +31,68 @@ type (
 	Body []*Expr
 
 	// Expr represents a single expression contained inside the body of a rule.
+	// Negated expressions, e.g. not input.blocked, succeed if the expression
+	// does not.
 	Expr struct {
 		Location  *term.Location `json:"-"`
 		Generated bool           `json:"generated,omitempty"`
 		Index     int            `json:"index"`
+		Negated   bool           `json:"negated,omitempty"`
 		Terms     interface{}    `json:"terms"`
+		With      []*With        `json:"with,omitempty"`
 	}
 
 	// Module represents a collection of policies (defined by rules)
 	// within a namespace (defined by the package) and optional
-	// dependencies on external documents (defined by imports).
+	// dependencies on external documents (defined by imports). The
+	// comments of the module are kept in the order of the source.
 	Module struct {
-		Package *Package `json:"package"`
-		Rules   []*Rule  `json:"rules,omitempty"`
+		Package  *Package   `json:"package"`
+		Imports  []*Import  `json:"imports,omitempty"`
+		Rules    []*Rule    `json:"rules,omitempty"`
+		Comments []*Comment `json:"comments,omitempty"`
 	}
 
 	// Package represents the namespace of the documents produced
 	// by rules inside the module.
 	Package struct {
+		Location    *term.Location `json:"-"`
+		Path        term.Ref       `json:"path"`
+		Annotations []*Annotations `json:"annotations,omitempty"`
+	}
+
+	// Import represents a dependency on a document outside of the module's
+	// package, e.g. import zego.lib.users or import input.request as req.
+	Import struct {
 		Location *term.Location `json:"-"`
-		Path     term.Ref       `json:"path"`
+		Path     *term.Term     `json:"path"`
+		Alias    term.Var       `json:"alias,omitempty"`
 	}
 
 	// Rule represents a rule as defined in the language. Rules define the
-	// content of documents that represent policy decisions.
+	// content of documents that represent policy decisions. Rules with Args
+	// are user functions, e.g. is_admin(user) := true { user.role == "admin" }.
+	// Default rules provide the value of the document when no other rule for
+	// the same path produces one, e.g. default allow := false. Partial rules
+	// have a Key and produce a set, e.g. deny contains msg { ... }, or an
+	// object, e.g. headers[k] := v { ... }, from all of their definitions.
 	Rule struct {
 		Location *term.Location `json:"-"`
+		Default  bool           `json:"default,omitempty"`
 		Name     term.Var       `json:"name,omitempty"`
+		Args     Args           `json:"args,omitempty"`
+		Key      *term.Term     `json:"key,omitempty"`
 		Value    *term.Term     `json:"value,omitempty"`
 		Body     Body           `json:"body"`
 
+		// Else is the next rule of an ordered chain, e.g. a := 1 { x } else := 2.
+		// It is only evaluated if the rule's body is not satisfied.
+		Else *Rule `json:"else,omitempty"`
+
+		// Annotations holds the metadata given above the rule. The rules of
+		// an else chain share the annotations of the first rule.
+		Annotations []*Annotations `json:"annotations,omitempty"`
+
 		// Module is a pointer to the module containing this rule. If the rule
 		// was NOT created while parsing/constructing a module, this should be
 		// left unset. The pointer is not included in any standard operations
@@ -69,6 +101,32 @@ type (
 	}
 )
 
+// RuleKind is the kind of document a rule produces.
+type RuleKind int
+
+const (
+	// CompleteRule produces a single value, e.g. allow := true { ... }.
+	CompleteRule RuleKind = iota
+	// PartialSetRule produces the elements of a set, e.g. deny contains msg { ... }.
+	PartialSetRule
+	// PartialObjectRule produces the items of an object, e.g. a[k] := v { ... }.
+	PartialObjectRule
+	// FunctionRule produces a value for its arguments, e.g. f(x) := y { ... }.
+	FunctionRule
+)
+
+func (k RuleKind) String() string {
+	switch k {
+	case PartialSetRule:
+		return "partial set rule"
+	case PartialObjectRule:
+		return "partial object rule"
+	case FunctionRule:
+		return "function"
+	}
+	return "complete rule"
+}
+
 // NewExpr returns a new Expr object.
 func NewExpr(terms interface{}) *Expr {
 	return &Expr{
@@ -81,6 +139,40 @@ func (e *Expr) SetLoc(l *term.Location) {
 	e.Location = l
 }
 
+// IsDeclare returns true if the expression declares a variable, e.g. x := 1.
+func (e *Expr) IsDeclare() bool {
+	t, ok := e.Terms.(*term.Term)
+	if !ok {
+		return false
+	}
+	call, ok := t.Value.(term.Call)
+	return ok && len(call) == 3 && call[0].Value.Equal(term.Op("declare"))
+}
+
+// IsUnify returns true if the expression unifies two terms, e.g. [x, 1] = y.
+func (e *Expr) IsUnify() bool {
+	t, ok := e.Terms.(*term.Term)
+	if !ok {
+		return false
+	}
+	call, ok := t.Value.(term.Call)
+	return ok && len(call) == 3 && call[0].Value.Equal(term.Op("unify"))
+}
+
+// IsSome returns true if the expression iterates over a collection, e.g. some
+// x in input.items.
+func (e *Expr) IsSome() bool {
+	_, ok := e.Terms.(*SomeDecl)
+	return ok
+}
+
+// IsEvery returns true if the expression quantifies over a collection, e.g.
+// every x in input.items { x.enabled }.
+func (e *Expr) IsEvery() bool {
+	_, ok := e.Terms.(*Every)
+	return ok
+}
+
 // Compare returns an integer indicating whether expr is less than, equal to,
 // or greater than other.
 //
@@ -128,28 +220,86 @@ func (e *Expr) Compare(other *Expr) int {
 		if cmp := term.TermSliceCompare(t, other.Terms.([]*term.Term)); cmp != 0 {
 			return cmp
 		}
+	case *SomeDecl:
+		if cmp := t.Compare(other.Terms.(*SomeDecl)); cmp != 0 {
+			return cmp
+		}
+	case *Every:
+		if cmp := t.Compare(other.Terms.(*Every)); cmp != 0 {
+			return cmp
+		}
 	}
-	return 0
+
+	for i := 0; i < len(e.With) && i < len(other.With); i++ {
+		if cmp := e.With[i].Compare(other.With[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(e.With) - len(other.With)
+}
+
+// Hash returns the hash code of the expression.
+func (e *Expr) Hash() int {
+	var hash int
+	switch t := e.Terms.(type) {
+	case *term.Term:
+		hash = t.Value.Hash()
+	case []*term.Term:
+		hash = term.Call(t).Hash()
+	case *SomeDecl:
+		hash = t.Hash()
+	case *Every:
+		hash = t.Hash()
+	}
+	if e.Negated {
+		hash++
+	}
+	for _, w := range e.With {
+		hash += w.Hash()
+	}
+	return hash
 }
 
+// sortOrder orders single term expressions before built-in expressions, some
+// declarations and every expressions and, within each of them, non-negated
+// expressions before negated ones.
 func (e *Expr) sortOrder() int {
+	o := -2
 	switch e.Terms.(type) {
 	case *term.Term:
-		return 0
+		o = 0
 	case []*term.Term:
-		return 1
+		o = 2
+	case *SomeDecl:
+		o = 4
+	case *Every:
+		o = 6
+	}
+	if e.Negated {
+		o++
 	}
-	return -1
+	return o
 }
 
 func (e *Expr) String() string {
+	var s string
 	switch t := e.Terms.(type) {
 	case []*term.Term:
-		return term.Call(t).String()
+		s = term.Call(t).String()
 	case *term.Term:
-		return t.String()
+		s = t.String()
+	case *SomeDecl:
+		s = t.String()
+	case *Every:
+		s = t.String()
 	}
-	return ""
+	for _, w := range e.With {
+		s += " " + w.String()
+	}
+	if e.Negated {
+		return "not " + s
+	}
+	return s
 }
 
 func (p *Package) Loc() *term.Location {
@@ -176,6 +326,56 @@ func (p *Package) String() string {
 	return fmt.Sprintf("package %v", path)
 }
 
+func (imp *Import) Loc() *term.Location {
+	return imp.Location
+}
+
+func (imp *Import) SetLoc(l *term.Location) {
+	imp.Location = l
+}
+
+// Equal returns true if imp is equal to other.
+func (imp *Import) Equal(other *Import) bool {
+	return imp.Compare(other) == 0
+}
+
+// Compare returns an integer indicating whether imp is less than, equal to,
+// or greater than other.
+func (imp *Import) Compare(other *Import) int {
+	if cmp := imp.Path.Compare(other.Path); cmp != 0 {
+		return cmp
+	}
+	return imp.Alias.Compare(other.Alias)
+}
+
+// Name returns the variable the import is referred to by inside of the module.
+// This is the alias if one is given, otherwise the last element of the path,
+// e.g. users for import zego.lib.users.
+func (imp *Import) Name() term.Var {
+	if imp.Alias != "" {
+		return imp.Alias
+	}
+	switch v := imp.Path.Value.(type) {
+	case term.Var:
+		return v
+	case term.Ref:
+		switch last := v[len(v)-1].Value.(type) {
+		case term.Var:
+			return last
+		case term.String:
+			return term.Var(last)
+		}
+	}
+	return ""
+}
+
+func (imp *Import) String() string {
+	if imp.Alias == "" {
+		return fmt.Sprintf("import %v", imp.Path)
+	}
+	return fmt.Sprintf("import %v as %v", imp.Path, imp.Alias)
+}
+
 func (r *Rule) Loc() *term.Location {
 	return r.Location
 }
@@ -200,20 +400,109 @@ func (rule *Rule) Compare(other *Rule) int {
 	} else if other == nil {
 		return 1
 	}
+	if rule.Default != other.Default {
+		if !rule.Default {
+			return -1
+		}
+		return 1
+	}
 	if cmp := rule.Name.Compare(other.Name); cmp != 0 {
 		return cmp
 	}
+	if cmp := rule.Args.Compare(other.Args); cmp != 0 {
+		return cmp
+	}
+	if cmp := rule.Key.Compare(other.Key); cmp != 0 {
+		return cmp
+	}
 	if cmp := rule.Value.Compare(other.Value); cmp != 0 {
 		return cmp
 	}
-	return rule.Body.Compare(other.Body)
+	if cmp := rule.Body.Compare(other.Body); cmp != 0 {
+		return cmp
+	}
+	return rule.Else.Compare(other.Else)
 }
 
 func (r *Rule) String() string {
+	name := r.Name.String()
+	if r.Default {
+		name = "default " + name
+	}
+	switch r.Kind() {
+	case FunctionRule:
+		name += r.Args.String()
+	case PartialSetRule:
+		name += " contains " + r.Key.String()
+	case PartialObjectRule:
+		name += "[" + r.Key.String() + "]"
+	}
+	return name + r.valueString()
+}
+
+func (r *Rule) valueString() string {
+	var s string
 	if r.Value == nil {
-		return r.Name.String() + " {\n" + r.Body.String() + "\n}\n"
+		s = " {\n" + r.Body.String() + "\n}"
+	} else {
+		s = " := " + r.Value.String() + " {\n" + r.Body.String() + "\n}"
+	}
+	if r.Else != nil {
+		return s + " else" + r.Else.valueString()
+	}
+	return s + "\n"
+}
+
+// Kind returns the kind of document the rule produces.
+func (r *Rule) Kind() RuleKind {
+	switch {
+	case r.IsFunction():
+		return FunctionRule
+	case r.Key != nil && r.Value == nil:
+		return PartialSetRule
+	case r.Key != nil:
+		return PartialObjectRule
+	}
+	return CompleteRule
+}
+
+// IsFunction returns true if the rule is a user function, i.e. it declares
+// arguments. Functions without arguments are written f().
+func (r *Rule) IsFunction() bool {
+	return r.Args != nil
+}
+
+// Compare returns an integer indicating whether args is less than, equal to,
+// or greater than other. Rules without arguments are less than functions.
+func (args Args) Compare(other Args) int {
+	switch {
+	case args == nil && other == nil:
+		return 0
+	case args == nil:
+		return -1
+	case other == nil:
+		return 1
+	}
+	return term.TermSliceCompare(args, other)
+}
+
+// Vars returns the variables declared by the arguments.
+func (args Args) Vars() []term.Var {
+	var vars []term.Var
+	WalkVars([]*term.Term(args), func(v term.Var) {
+		if !IsWildcard(v) {
+			vars = append(vars, v)
+		}
+	})
+	return vars
+}
+
+func (args Args) String() string {
+	buf := make([]string, len(args))
+	for i, arg := range args {
+		buf[i] = arg.String()
 	}
-	return r.Name.String() + " := " + r.Value.String() + " {\n" + r.Body.String() + "\n}\n"
+	return "(" + strings.Join(buf, ", ") + ")"
 }
 
 // NewBody returns a new Body containing the given expressions. The indices of
@@ -259,6 +548,15 @@ func (body Body) Compare(other Body) int {
 	return 0
 }
 
+// Hash returns the hash code of the body.
+func (body Body) Hash() int {
+	var hash int
+	for _, expr := range body {
+		hash += expr.Hash()
+	}
+	return hash
+}
+
 // Append adds the expr to the body and updates the expr's index accordingly.
 func (body *Body) Append(expr *Expr) {
 	n := len(*body)
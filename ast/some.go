@@ -0,0 +1,54 @@
+package ast
+
+import "avidbound.com/zego/ast/term"
+
+// SomeDecl represents the iteration over a collection as defined in the
+// language, e.g. some x in input.items or some k, v in input.headers. Key is
+// nil unless the key of each element is bound as well.
+type SomeDecl struct {
+	Key        *term.Term `json:"key,omitempty"`
+	Value      *term.Term `json:"value"`
+	Collection *term.Term `json:"collection"`
+}
+
+// Compare returns an integer indicating whether some is less than, equal to,
+// or greater than other.
+func (some *SomeDecl) Compare(other *SomeDecl) int {
+	if cmp := some.Key.Compare(other.Key); cmp != 0 {
+		return cmp
+	}
+	if cmp := some.Value.Compare(other.Value); cmp != 0 {
+		return cmp
+	}
+	return some.Collection.Compare(other.Collection)
+}
+
+// Hash returns the hash code of the declaration.
+func (some *SomeDecl) Hash() int {
+	hash := some.Value.Value.Hash() + some.Collection.Value.Hash()
+	if some.Key != nil {
+		hash += some.Key.Value.Hash()
+	}
+	return hash
+}
+
+func (some *SomeDecl) String() string {
+	if some.Key == nil {
+		return "some " + some.Value.String() + " in " + some.Collection.String()
+	}
+	return "some " + some.Key.String() + ", " + some.Value.String() + " in " + some.Collection.String()
+}
+
+// Vars returns the variables declared by the iteration.
+func (some *SomeDecl) Vars() []term.Var {
+	var vars []term.Var
+	for _, t := range []*term.Term{some.Key, some.Value} {
+		if t == nil {
+			continue
+		}
+		if v, ok := t.Value.(term.Var); ok && !IsWildcard(v) {
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
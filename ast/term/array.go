@@ -0,0 +1,50 @@
+package term
+
+import "strings"
+
+// Array represents an array as defined by the language. Unlike JSON arrays,
+// arrays may contain variables, references and calls.
+type Array []*Term
+
+// ArrayTerm creates a new Term with an Array value.
+func ArrayTerm(a ...*Term) *Term {
+	return &Term{Value: Array(a)}
+}
+
+// Equal returns true if the other Value is an Array and is equal.
+func (a Array) Equal(other Value) bool {
+	switch other := other.(type) {
+	case Array:
+		return a.Compare(other) == 0
+	default:
+		return false
+	}
+}
+
+// Compare compares arr to other, return <0, 0, or >0 if it is less than, equal to,
+// or greater than other.
+func (a Array) Compare(other Value) int {
+	if sort := compareSortOrder(a, other); sort != 0 {
+		return sort
+	}
+
+	o := other.(Array)
+	return TermSliceCompare(a, o)
+}
+
+func (a Array) String() string {
+	buf := make([]string, len(a))
+	for i, t := range a {
+		buf[i] = t.String()
+	}
+	return "[" + strings.Join(buf, ", ") + "]"
+}
+
+// Hash returns the hash code for the Value.
+func (a Array) Hash() int {
+	return termSliceHash(a)
+}
+
+func (a Array) SortOrder() int {
+	return 8
+}
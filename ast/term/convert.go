@@ -0,0 +1,92 @@
+package term
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// InterfaceToValue converts a native Go value x to a Value. The native value
+// is expected to be in the normalized form produced by encoding/json, i.e.
+// numbers are represented as json.Number or float64.
+func InterfaceToValue(x interface{}) (Value, error) {
+	switch x := x.(type) {
+	case nil:
+		return Null{}, nil
+	case bool:
+		return Boolean(x), nil
+	case json.Number:
+		return Number(x), nil
+	case float64:
+		return Number(strconv.FormatFloat(x, 'g', -1, 64)), nil
+	case int:
+		return Number(strconv.Itoa(x)), nil
+	case string:
+		return String(x), nil
+	case []interface{}:
+		a := make(Array, len(x))
+		for i, e := range x {
+			v, err := InterfaceToValue(e)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = NewTerm(v)
+		}
+		return a, nil
+	case map[string]interface{}:
+		pairs := make([][2]*Term, 0, len(x))
+		for k, e := range x {
+			v, err := InterfaceToValue(e)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, Item(StringTerm(k), NewTerm(v)))
+		}
+		return NewObject(pairs...), nil
+	default:
+		return nil, fmt.Errorf("illegal value: %T", x)
+	}
+}
+
+// ValueToInterface converts v to its native Go representation. Only values
+// that can be represented as JSON may be converted.
+func ValueToInterface(v Value) (interface{}, error) {
+	switch v := v.(type) {
+	case Null:
+		return nil, nil
+	case Boolean:
+		return bool(v), nil
+	case Number:
+		return json.Number(v), nil
+	case String:
+		return string(v), nil
+	case Set:
+		return ValueToInterface(Array(v))
+	case Array:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			x, err := ValueToInterface(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = x
+		}
+		return a, nil
+	case Object:
+		m := make(map[string]interface{}, len(v))
+		for _, pair := range v {
+			k := pair[0].String()
+			if s, ok := pair[0].Value.(String); ok {
+				k = string(s)
+			}
+			x, err := ValueToInterface(pair[1].Value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = x
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("%v cannot be converted to a native value", v)
+	}
+}
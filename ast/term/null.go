@@ -0,0 +1,38 @@
+package term
+
+// Null represents the null value defined by JSON.
+type Null struct{}
+
+// NullTerm creates a new Term with a Null value.
+func NullTerm() *Term {
+	return &Term{Value: Null{}}
+}
+
+// Equal returns true if the other Value is a Null.
+func (n Null) Equal(other Value) bool {
+	switch other.(type) {
+	case Null:
+		return true
+	default:
+		return false
+	}
+}
+
+// Compare compares null to other, return <0, 0, or >0 if it is less than, equal to,
+// or greater than other.
+func (n Null) Compare(other Value) int {
+	return compareSortOrder(n, other)
+}
+
+func (n Null) String() string {
+	return "null"
+}
+
+// Hash returns the hash code for the Value.
+func (n Null) Hash() int {
+	return 0
+}
+
+func (n Null) SortOrder() int {
+	return 0
+}
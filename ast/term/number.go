@@ -2,6 +2,7 @@ package term
 
 import (
 	"encoding/json"
+	"math/big"
 
 	"github.com/OneOfOne/xxhash"
 )
@@ -42,7 +43,16 @@ func (n Number) Compare(other Value) int {
 			return 1
 		}
 	}
-	return 0
+
+	a, ok := new(big.Float).SetString(string(n))
+	if !ok {
+		return 0
+	}
+	b, ok := new(big.Float).SetString(string(other.(Number)))
+	if !ok {
+		return 0
+	}
+	return a.Cmp(b)
 }
 
 func (n Number) String() string {
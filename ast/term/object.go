@@ -0,0 +1,126 @@
+package term
+
+import (
+	"sort"
+	"strings"
+)
+
+// Object represents an object as defined by the language. The key/value pairs
+// are kept sorted by key so that the key order is deterministic and objects
+// holding the same pairs compare and hash equally.
+type Object [][2]*Term
+
+// ObjectTerm creates a new Term with an Object value.
+func ObjectTerm(pairs ...[2]*Term) *Term {
+	return &Term{Value: NewObject(pairs...)}
+}
+
+// NewObject returns a new Object holding the pairs. If a key is repeated the
+// last pair with that key is kept.
+func NewObject(pairs ...[2]*Term) Object {
+	obj := Object{}
+	for _, pair := range pairs {
+		obj = obj.Insert(pair[0], pair[1])
+	}
+	return obj
+}
+
+// Item is a helper for constructing a key/value pair of an Object.
+func Item(key, value *Term) [2]*Term {
+	return [2]*Term{key, value}
+}
+
+// Get returns the value of the key k or nil if the object does not contain k.
+func (o Object) Get(k *Term) *Term {
+	i := o.search(k)
+	if i < len(o) && o[i][0].Value.Compare(k.Value) == 0 {
+		return o[i][1]
+	}
+	return nil
+}
+
+// Insert returns a copy of the object with the key k set to v.
+func (o Object) Insert(k, v *Term) Object {
+	i := o.search(k)
+	cpy := make(Object, 0, len(o)+1)
+	cpy = append(cpy, o[:i]...)
+	cpy = append(cpy, Item(k, v))
+	if i < len(o) && o[i][0].Value.Compare(k.Value) == 0 {
+		i++
+	}
+	return append(cpy, o[i:]...)
+}
+
+// Keys returns the keys of the object in sorted order.
+func (o Object) Keys() []*Term {
+	keys := make([]*Term, len(o))
+	for i, pair := range o {
+		keys[i] = pair[0]
+	}
+	return keys
+}
+
+func (o Object) search(k *Term) int {
+	return sort.Search(len(o), func(i int) bool {
+		return o[i][0].Value.Compare(k.Value) >= 0
+	})
+}
+
+// Equal returns true if the other Value is an Object and is equal.
+func (o Object) Equal(other Value) bool {
+	switch other := other.(type) {
+	case Object:
+		return o.Compare(other) == 0
+	default:
+		return false
+	}
+}
+
+// Compare compares obj to other, return <0, 0, or >0 if it is less than, equal to,
+// or greater than other.
+func (o Object) Compare(other Value) int {
+	if sort := compareSortOrder(o, other); sort != 0 {
+		return sort
+	}
+
+	b := other.(Object)
+	minLen := len(o)
+	if len(b) < minLen {
+		minLen = len(b)
+	}
+	for i := 0; i < minLen; i++ {
+		if cmp := o[i][0].Value.Compare(b[i][0].Value); cmp != 0 {
+			return cmp
+		}
+		if cmp := o[i][1].Value.Compare(b[i][1].Value); cmp != 0 {
+			return cmp
+		}
+	}
+	if len(o) < len(b) {
+		return -1
+	} else if len(b) < len(o) {
+		return 1
+	}
+	return 0
+}
+
+func (o Object) String() string {
+	buf := make([]string, len(o))
+	for i, pair := range o {
+		buf[i] = pair[0].String() + ": " + pair[1].String()
+	}
+	return "{" + strings.Join(buf, ", ") + "}"
+}
+
+// Hash returns the hash code for the Value.
+func (o Object) Hash() int {
+	var hash int
+	for _, pair := range o {
+		hash += pair[0].Value.Hash() + pair[1].Value.Hash()
+	}
+	return hash
+}
+
+func (o Object) SortOrder() int {
+	return 9
+}
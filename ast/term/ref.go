@@ -35,6 +35,14 @@ func (r Ref) Compare(other Value) int {
 	return TermSliceCompare(r, o)
 }
 
+// HasPrefix returns true if the first elements of r are equal to prefix.
+func (r Ref) HasPrefix(prefix Ref) bool {
+	if len(prefix) > len(r) {
+		return false
+	}
+	return TermSliceCompare(r[:len(prefix)], prefix) == 0
+}
+
 func (r Ref) String() string {
 	if len(r) == 0 {
 		return ""
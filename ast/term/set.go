@@ -0,0 +1,121 @@
+package term
+
+import (
+	"sort"
+	"strings"
+)
+
+// Set represents a set as defined by the language. The elements are kept
+// sorted and unique so that sets holding the same elements compare and hash
+// equally.
+type Set []*Term
+
+// SetTerm creates a new Term with a Set value.
+func SetTerm(t ...*Term) *Term {
+	return &Term{Value: NewSet(t...)}
+}
+
+// NewSet returns a new Set holding the terms.
+func NewSet(t ...*Term) Set {
+	s := Set{}
+	for _, x := range t {
+		s = s.Add(x)
+	}
+	return s
+}
+
+// Contains returns true if the set contains x.
+func (s Set) Contains(x *Term) bool {
+	i := s.search(x)
+	return i < len(s) && s[i].Value.Compare(x.Value) == 0
+}
+
+// Add returns a copy of the set with x added.
+func (s Set) Add(x *Term) Set {
+	i := s.search(x)
+	if i < len(s) && s[i].Value.Compare(x.Value) == 0 {
+		return s
+	}
+	cpy := make(Set, 0, len(s)+1)
+	cpy = append(cpy, s[:i]...)
+	cpy = append(cpy, x)
+	return append(cpy, s[i:]...)
+}
+
+// Union returns the set of elements contained in s or other.
+func (s Set) Union(other Set) Set {
+	result := s
+	for _, x := range other {
+		result = result.Add(x)
+	}
+	return result
+}
+
+// Intersect returns the set of elements contained in both s and other.
+func (s Set) Intersect(other Set) Set {
+	result := Set{}
+	for _, x := range s {
+		if other.Contains(x) {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+// Diff returns the set of elements contained in s but not in other.
+func (s Set) Diff(other Set) Set {
+	result := Set{}
+	for _, x := range s {
+		if !other.Contains(x) {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+func (s Set) search(x *Term) int {
+	return sort.Search(len(s), func(i int) bool {
+		return s[i].Value.Compare(x.Value) >= 0
+	})
+}
+
+// Equal returns true if the other Value is a Set and is equal.
+func (s Set) Equal(other Value) bool {
+	switch other := other.(type) {
+	case Set:
+		return s.Compare(other) == 0
+	default:
+		return false
+	}
+}
+
+// Compare compares set to other, return <0, 0, or >0 if it is less than, equal to,
+// or greater than other.
+func (s Set) Compare(other Value) int {
+	if sort := compareSortOrder(s, other); sort != 0 {
+		return sort
+	}
+
+	o := other.(Set)
+	return TermSliceCompare(s, o)
+}
+
+func (s Set) String() string {
+	if len(s) == 0 {
+		return "set()"
+	}
+	buf := make([]string, len(s))
+	for i, t := range s {
+		buf[i] = t.String()
+	}
+	return "{" + strings.Join(buf, ", ") + "}"
+}
+
+// Hash returns the hash code for the Value.
+func (s Set) Hash() int {
+	return termSliceHash(s)
+}
+
+func (s Set) SortOrder() int {
+	return 10
+}
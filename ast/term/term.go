@@ -22,10 +22,18 @@ type (
 		SortOrder() int          // Returns the sort order of the value.
 	}
 
+	// Location is the span of a node in the source. Lines and columns start at
+	// 1, offsets are byte offsets in the source starting at 0. The end is the
+	// position following the last byte of the node.
 	Location struct {
-		File   string `json:"file"`
-		Line   int    `json:"line"`
-		Column int    `json:"column"`
+		File      string `json:"file"`
+		Line      int    `json:"line"`
+		Column    int    `json:"column"`
+		Offset    int    `json:"offset"`
+		EndLine   int    `json:"end_line"`
+		EndColumn int    `json:"end_column"`
+		EndOffset int    `json:"end_offset"`
+		Text      []byte `json:"-"` // the source of the node, shared with the module; do not modify
 	}
 )
 
@@ -41,18 +49,53 @@ func (t *Term) SetLoc(l *Location) *Term {
 	return t
 }
 
+// Compare compares t to other, nil terms are less than all other terms.
 func (t *Term) Compare(other *Term) int {
-	switch v := t.Value.(type) {
-	case Boolean, Call, Number, Op, Ref, String, Var:
-		return v.Compare(other.Value)
+	switch {
+	case t == nil && other == nil:
+		return 0
+	case t == nil:
+		return -1
+	case other == nil:
+		return 1
 	}
-	return 0
+	return t.Value.Compare(other.Value)
 }
 
 func (t *Term) String() string {
 	return t.Value.String()
 }
 
+// IsGround returns true if v contains no variables, references, calls or
+// other values that must be evaluated, such as comprehensions.
+func IsGround(v Value) bool {
+	switch v := v.(type) {
+	case Null, Boolean, Number, String:
+		return true
+	case Array:
+		for _, t := range v {
+			if !IsGround(t.Value) {
+				return false
+			}
+		}
+	case Set:
+		for _, t := range v {
+			if !IsGround(t.Value) {
+				return false
+			}
+		}
+	case Object:
+		for _, pair := range v {
+			if !IsGround(pair[0].Value) || !IsGround(pair[1].Value) {
+				return false
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
 func compareSortOrder(a, b Value) int {
 	ao := a.SortOrder()
 	bo := b.SortOrder()
@@ -0,0 +1,26 @@
+package ast
+
+import "avidbound.com/zego/ast/term"
+
+var (
+	// RootDocument is the root of the documents produced by rules, e.g. the
+	// rule "a" in "package test" is referred to as zego.test.a.
+	RootDocument = term.VarTerm("zego")
+
+	// InputRootDocument is the root of the document supplied to an evaluation.
+	InputRootDocument = term.VarTerm("input")
+
+	// Wildcard is the anonymous variable "_". Every occurrence of the wildcard
+	// is distinct, so it is never bound to a value.
+	Wildcard = term.VarTerm("_")
+)
+
+// IsRootDocument returns true if v names one of the root documents.
+func IsRootDocument(v term.Var) bool {
+	return RootDocument.Value.Equal(v) || InputRootDocument.Value.Equal(v)
+}
+
+// IsWildcard returns true if v is the anonymous variable.
+func IsWildcard(v term.Var) bool {
+	return Wildcard.Value.Equal(v)
+}
@@ -0,0 +1,139 @@
+package ast
+
+import "avidbound.com/zego/ast/term"
+
+// WalkTerms calls f on every term contained in x. If f returns true the terms
+// nested inside of the visited term are skipped.
+func WalkTerms(x interface{}, f func(*term.Term) bool) {
+	switch x := x.(type) {
+	case *Module:
+		for _, r := range x.Rules {
+			WalkTerms(r, f)
+		}
+	case *Rule:
+		WalkTerms([]*term.Term(x.Args), f)
+		WalkTerms(x.Key, f)
+		WalkTerms(x.Value, f)
+		WalkTerms(x.Body, f)
+		if x.Else != nil {
+			WalkTerms(x.Else, f)
+		}
+	case Body:
+		for _, e := range x {
+			WalkTerms(e, f)
+		}
+	case *Expr:
+		WalkTerms(x.Terms, f)
+		for _, w := range x.With {
+			WalkTerms(w.Target, f)
+			WalkTerms(w.Value, f)
+		}
+	case *SomeDecl:
+		WalkTerms(x.Key, f)
+		WalkTerms(x.Value, f)
+		WalkTerms(x.Collection, f)
+	case *Every:
+		WalkTerms(x.Key, f)
+		WalkTerms(x.Value, f)
+		WalkTerms(x.Collection, f)
+		WalkTerms(x.Body, f)
+	case []*term.Term:
+		for _, t := range x {
+			WalkTerms(t, f)
+		}
+	case *term.Term:
+		if x == nil || f(x) {
+			return
+		}
+		switch v := x.Value.(type) {
+		case term.Ref:
+			WalkTerms([]*term.Term(v), f)
+		case term.Call:
+			WalkTerms([]*term.Term(v), f)
+		case term.Array:
+			WalkTerms([]*term.Term(v), f)
+		case term.Set:
+			WalkTerms([]*term.Term(v), f)
+		case term.Object:
+			for _, pair := range v {
+				WalkTerms(pair[0], f)
+				WalkTerms(pair[1], f)
+			}
+		case *ArrayComprehension:
+			WalkTerms(v.Term, f)
+			WalkTerms(v.Body, f)
+		case *SetComprehension:
+			WalkTerms(v.Term, f)
+			WalkTerms(v.Body, f)
+		case *ObjectComprehension:
+			WalkTerms(v.Key, f)
+			WalkTerms(v.Value, f)
+			WalkTerms(v.Body, f)
+		}
+	}
+}
+
+// WalkVars calls f on every variable contained in x.
+func WalkVars(x interface{}, f func(term.Var)) {
+	WalkTerms(x, func(t *term.Term) bool {
+		if v, ok := t.Value.(term.Var); ok {
+			f(v)
+		}
+		return false
+	})
+}
+
+// WalkExprs calls f on every expression contained in x, including the
+// expressions in the bodies of every and comprehensions.
+func WalkExprs(x interface{}, f func(*Expr)) {
+	switch x := x.(type) {
+	case *Module:
+		for _, r := range x.Rules {
+			WalkExprs(r, f)
+		}
+	case *Rule:
+		WalkExprs(x.Key, f)
+		WalkExprs(x.Value, f)
+		WalkExprs(x.Body, f)
+		if x.Else != nil {
+			WalkExprs(x.Else, f)
+		}
+	case Body:
+		for _, e := range x {
+			WalkExprs(e, f)
+		}
+	case *Expr:
+		f(x)
+		switch t := x.Terms.(type) {
+		case *term.Term:
+			WalkExprs(t, f)
+		case *SomeDecl:
+			WalkExprs(t.Collection, f)
+		case *Every:
+			WalkExprs(t.Collection, f)
+			WalkExprs(t.Body, f)
+		}
+		for _, w := range x.With {
+			WalkExprs(w.Value, f)
+		}
+	case *term.Term:
+		WalkTerms(x, func(t *term.Term) bool {
+			switch v := t.Value.(type) {
+			case *ArrayComprehension:
+				WalkExprs(v.Term, f)
+				WalkExprs(v.Body, f)
+				return true
+			case *SetComprehension:
+				WalkExprs(v.Term, f)
+				WalkExprs(v.Body, f)
+				return true
+			case *ObjectComprehension:
+				WalkExprs(v.Key, f)
+				WalkExprs(v.Value, f)
+				WalkExprs(v.Body, f)
+				return true
+			}
+			return false
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package ast
+
+import "avidbound.com/zego/ast/term"
+
+// With represents a modifier on an expression as defined in the language, e.g.
+// with input.user as {"role": "admin"}. The target document or function is
+// replaced by the value while the expression is evaluated.
+type With struct {
+	Location *term.Location `json:"-"`
+	Target   *term.Term     `json:"target"`
+	Value    *term.Term     `json:"value"`
+}
+
+func (w *With) Loc() *term.Location {
+	return w.Location
+}
+
+func (w *With) SetLoc(l *term.Location) {
+	w.Location = l
+}
+
+// Compare returns an integer indicating whether w is less than, equal to, or
+// greater than other.
+func (w *With) Compare(other *With) int {
+	if cmp := w.Target.Compare(other.Target); cmp != 0 {
+		return cmp
+	}
+	return w.Value.Compare(other.Value)
+}
+
+// Hash returns the hash code of the modifier.
+func (w *With) Hash() int {
+	return w.Target.Value.Hash() + w.Value.Value.Hash()
+}
+
+func (w *With) String() string {
+	return "with " + w.Target.String() + " as " + w.Value.String()
+}
@@ -2,4 +2,7 @@ module avidbound.com/zego
 
 go 1.15
 
-require github.com/OneOfOne/xxhash v1.2.8
+require (
+	github.com/OneOfOne/xxhash v1.2.8
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -14,7 +14,7 @@ func main() {
 		package test
 		a := input.a`
 
-	input := `{"a": "test"}`
+	input := map[string]interface{}{"a": "test"}
 
 	query, err := zego.New(
 		zego.Query("x := zego.test.a"),
@@ -32,6 +32,6 @@ func main() {
 	}
 
 	if len(rs) > 0 {
-		fmt.Println(rs[0])
+		fmt.Println(rs[0].Bindings["x"])
 	}
 }
@@ -0,0 +1,117 @@
+package topdown
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"avidbound.com/zego/ast/term"
+)
+
+func builtinArithmetic(f func(a, b *big.Float) (*big.Float, error)) BuiltinFunc {
+	return func(operands []term.Value) (term.Value, error) {
+		if err := checkArity(operands, 2); err != nil {
+			return nil, err
+		}
+		a, err := numberOperand(operands, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := numberOperand(operands, 1)
+		if err != nil {
+			return nil, err
+		}
+		c, err := f(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return floatToNumber(c), nil
+	}
+}
+
+// builtinNegate returns the number given as the only operand with its sign
+// inverted.
+func builtinNegate(operands []term.Value) (term.Value, error) {
+	if err := checkArity(operands, 1); err != nil {
+		return nil, err
+	}
+	a, err := numberOperand(operands, 0)
+	if err != nil {
+		return nil, err
+	}
+	return floatToNumber(new(big.Float).Neg(a)), nil
+}
+
+func arithAdd(a, b *big.Float) (*big.Float, error) {
+	return new(big.Float).Add(a, b), nil
+}
+
+func arithSubtract(a, b *big.Float) (*big.Float, error) {
+	return new(big.Float).Sub(a, b), nil
+}
+
+func arithMultiply(a, b *big.Float) (*big.Float, error) {
+	return new(big.Float).Mul(a, b), nil
+}
+
+func arithDivide(a, b *big.Float) (*big.Float, error) {
+	if b.Sign() == 0 {
+		return nil, fmt.Errorf("divide by zero")
+	}
+	return new(big.Float).Quo(a, b), nil
+}
+
+func arithModulus(a, b *big.Float) (*big.Float, error) {
+	if !a.IsInt() || !b.IsInt() {
+		return nil, fmt.Errorf("modulo on floating-point number")
+	}
+	x, _ := a.Int(nil)
+	y, _ := b.Int(nil)
+	if y.Sign() == 0 {
+		return nil, fmt.Errorf("modulo by zero")
+	}
+	return new(big.Float).SetInt(new(big.Int).Rem(x, y)), nil
+}
+
+func numberOperand(operands []term.Value, i int) (*big.Float, error) {
+	n, ok := operands[i].(term.Number)
+	if !ok {
+		return nil, fmt.Errorf("operand %d must be number but got %v", i+1, typeName(operands[i]))
+	}
+	f, ok := new(big.Float).SetString(string(n))
+	if !ok {
+		return nil, fmt.Errorf("operand %d is not a valid number: %v", i+1, n)
+	}
+	return f, nil
+}
+
+// floatToNumber returns f as a Number. Integers are kept exact, other values
+// are rounded to the nearest float64 as they would be in JSON.
+func floatToNumber(f *big.Float) term.Number {
+	if f.IsInt() {
+		i, _ := f.Int(nil)
+		return term.Number(i.String())
+	}
+	x, _ := f.Float64()
+	return term.Number(strconv.FormatFloat(x, 'g', -1, 64))
+}
+
+func typeName(v term.Value) string {
+	switch v.(type) {
+	case term.Null:
+		return "null"
+	case term.Boolean:
+		return "boolean"
+	case term.Number:
+		return "number"
+	case term.String:
+		return "string"
+	case term.Array:
+		return "array"
+	case term.Object:
+		return "object"
+	case term.Set:
+		return "set"
+	}
+	return fmt.Sprintf("%T", v)
+}
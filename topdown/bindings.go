@@ -0,0 +1,39 @@
+package topdown
+
+import (
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+// bindings holds the values of the variables bound in a single scope. Nested
+// scopes, such as comprehension bodies, can read the bindings of their parent.
+type bindings struct {
+	values map[term.Var]term.Value
+	parent *bindings
+}
+
+func newBindings() *bindings {
+	return &bindings{
+		values: map[term.Var]term.Value{},
+	}
+}
+
+func (b *bindings) get(v term.Var) (term.Value, bool) {
+	for ; b != nil; b = b.parent {
+		if x, ok := b.values[v]; ok {
+			return x, true
+		}
+	}
+	return nil, false
+}
+
+// bind binds v to x for the duration of iter. The wildcard is never bound.
+func (b *bindings) bind(v term.Var, x term.Value, iter func() error) error {
+	if ast.IsWildcard(v) {
+		return iter()
+	}
+	b.values[v] = x
+	err := iter()
+	delete(b.values, v)
+	return err
+}
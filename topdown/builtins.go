@@ -0,0 +1,86 @@
+package topdown
+
+import (
+	"fmt"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+// BuiltinFunc is the signature of a built-in function. Returning a nil Value
+// means the result of the call is undefined.
+type BuiltinFunc func(operands []term.Value) (term.Value, error)
+
+var builtinFunctions = map[string]BuiltinFunc{}
+
+// RegisterBuiltin adds fn to the built-in functions under name. Operators are
+// registered under the name of their operator term, e.g. "add".
+func RegisterBuiltin(name string, fn BuiltinFunc) {
+	builtinFunctions[name] = fn
+	ast.RegisterBuiltin(name)
+}
+
+func init() {
+	RegisterBuiltin("equal", builtinCompare(func(cmp int) bool { return cmp == 0 }))
+	RegisterBuiltin("nEqual", builtinCompare(func(cmp int) bool { return cmp != 0 }))
+	RegisterBuiltin("lt", builtinCompare(func(cmp int) bool { return cmp < 0 }))
+	RegisterBuiltin("gt", builtinCompare(func(cmp int) bool { return cmp > 0 }))
+	RegisterBuiltin("lte", builtinCompare(func(cmp int) bool { return cmp <= 0 }))
+	RegisterBuiltin("gte", builtinCompare(func(cmp int) bool { return cmp >= 0 }))
+
+	RegisterBuiltin("add", builtinArithmetic(arithAdd))
+	RegisterBuiltin("minus", builtinMinus)
+	RegisterBuiltin("multiply", builtinArithmetic(arithMultiply))
+	RegisterBuiltin("divide", builtinArithmetic(arithDivide))
+	RegisterBuiltin("modulus", builtinArithmetic(arithModulus))
+	RegisterBuiltin("negate", builtinNegate)
+
+	RegisterBuiltin("not", builtinNot)
+
+	RegisterBuiltin("and", builtinSet(term.Set.Intersect))
+	RegisterBuiltin("or", builtinSet(term.Set.Union))
+
+	RegisterBuiltin("in", builtinMember)
+
+	RegisterBuiltin("time.now_ns", builtinNowNs)
+}
+
+// builtin returns the built-in function called by op. Functions that depend on
+// the state of the evaluation are bound to e.
+func (e *eval) builtin(op *term.Term) (BuiltinFunc, bool) {
+	if fn, ok := evalFunctions[op.String()]; ok {
+		return func(operands []term.Value) (term.Value, error) {
+			return fn(e, operands)
+		}, true
+	}
+	fn, ok := builtinFunctions[op.String()]
+	return fn, ok
+}
+
+func builtinCompare(f func(cmp int) bool) BuiltinFunc {
+	return func(operands []term.Value) (term.Value, error) {
+		if err := checkArity(operands, 2); err != nil {
+			return nil, err
+		}
+		return term.Boolean(f(operands[0].Compare(operands[1]))), nil
+	}
+}
+
+// builtinNot returns the complement of the boolean given as the only operand.
+func builtinNot(operands []term.Value) (term.Value, error) {
+	if err := checkArity(operands, 1); err != nil {
+		return nil, err
+	}
+	b, ok := operands[0].(term.Boolean)
+	if !ok {
+		return nil, fmt.Errorf("operand 1 must be boolean but got %v", typeName(operands[0]))
+	}
+	return !b, nil
+}
+
+func checkArity(operands []term.Value, n int) error {
+	if len(operands) != n {
+		return fmt.Errorf("expected %d operands but got %d", n, len(operands))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package topdown
+
+import (
+	"fmt"
+
+	"avidbound.com/zego/ast/term"
+)
+
+const (
+	// ConflictErr indicates that a rule produced conflicting values.
+	ConflictErr = "eval_conflict_error"
+
+	// TypeErr indicates that an operand had an unexpected type.
+	TypeErr = "eval_type_error"
+
+	// BuiltinErr indicates that a built-in function failed.
+	BuiltinErr = "eval_builtin_error"
+
+	// UnboundErr indicates that a variable was read before it was bound.
+	UnboundErr = "eval_unbound_error"
+
+	// RecursionErr indicates that a rule was reached while it was evaluated.
+	RecursionErr = "eval_recursion_error"
+)
+
+// Error represents a single error caught during evaluation.
+type Error struct {
+	Code     string         `json:"code"`
+	Message  string         `json:"message"`
+	Location *term.Location `json:"location,omitempty"`
+}
+
+func newError(code string, loc *term.Location, f string, a ...interface{}) *Error {
+	return &Error{
+		Code:     code,
+		Location: loc,
+		Message:  fmt.Sprintf(f, a...),
+	}
+}
+
+func (e *Error) Error() string {
+
+	msg := fmt.Sprintf("%v: %v", e.Code, e.Message)
+
+	if e.Location != nil {
+		if len(e.Location.File) > 0 {
+			msg = e.Location.File + ":" + fmt.Sprint(e.Location.Line) + ": " + msg
+		} else {
+			msg = fmt.Sprint(e.Location.Line) + ":" + fmt.Sprint(e.Location.Column) + ": " + msg
+		}
+	}
+
+	return msg
+}
@@ -0,0 +1,779 @@
+package topdown
+
+import (
+	"context"
+	"strconv"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/compile"
+	"avidbound.com/zego/ast/term"
+)
+
+// eval holds the state of a top-down evaluation. Expressions are evaluated in
+// order; every time an expression succeeds the remaining expressions are
+// evaluated by calling the continuation, which gives backtracking over
+// alternative variable bindings for free.
+type eval struct {
+	ctx      context.Context
+	compiler *compile.Compiler
+	input    *term.Term
+	bindings *bindings
+	virtual  map[*compile.TreeNode]term.Value // cache of evaluated rules
+	active   map[*compile.TreeNode]bool       // rules being evaluated
+	data     map[*compile.TreeNode]term.Value // documents replaced by with
+	mocks    map[string]mock                  // functions replaced by with
+	rule     *ast.Rule                        // rule being evaluated, nil for the query
+}
+
+// child returns a new eval sharing the documents of e with an empty scope.
+func (e *eval) child() *eval {
+	cpy := *e
+	cpy.bindings = newBindings()
+	return &cpy
+}
+
+// closure returns a new eval with a nested scope that can read the bindings
+// of e. Variables bound in the nested scope are not visible to e.
+func (e *eval) closure() *eval {
+	cpy := *e
+	cpy.bindings = newBindings()
+	cpy.bindings.parent = e.bindings
+	return &cpy
+}
+
+func (e *eval) evalBody(body ast.Body, iter func() error) error {
+	return e.evalExprs(body, 0, iter)
+}
+
+func (e *eval) evalExprs(body ast.Body, index int, iter func() error) error {
+	if index == len(body) {
+		return iter()
+	}
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	return e.evalExpr(body[index], func(term.Value) error {
+		return e.evalExprs(body, index+1, iter)
+	})
+}
+
+// evalQuery evaluates the body like evalExprs while recording the value of
+// each expression in values.
+func (e *eval) evalQuery(body ast.Body, values []term.Value, index int, iter func() error) error {
+	if index == len(body) {
+		return iter()
+	}
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	return e.evalExpr(body[index], func(v term.Value) error {
+		values[index] = v
+		return e.evalQuery(body, values, index+1, iter)
+	})
+}
+
+// evalExpr calls iter with the value of the expression for each way in which
+// the expression succeeds. Declarations have the value true. Modifiers only
+// apply while the expression itself is evaluated.
+func (e *eval) evalExpr(expr *ast.Expr, iter func(term.Value) error) error {
+	if len(expr.With) > 0 {
+		return e.evalWith(expr.With, 0, e.modified(), func(cpy *eval) error {
+			return cpy.evalExprTerms(expr, iter)
+		})
+	}
+	return e.evalExprTerms(expr, iter)
+}
+
+func (e *eval) evalExprTerms(expr *ast.Expr, iter func(term.Value) error) error {
+	if some, ok := expr.Terms.(*ast.SomeDecl); ok {
+		return e.evalSome(some, func() error {
+			return iter(term.Boolean(true))
+		})
+	}
+
+	if every, ok := expr.Terms.(*ast.Every); ok {
+		return e.evalEvery(every, func() error {
+			return iter(term.Boolean(true))
+		})
+	}
+
+	t, ok := expr.Terms.(*term.Term)
+	if !ok {
+		return newError(TypeErr, expr.Location, "illegal expression %v", expr)
+	}
+
+	if expr.IsDeclare() {
+		return e.evalDeclare(t.Value.(term.Call), func() error {
+			return iter(term.Boolean(true))
+		})
+	}
+
+	if expr.Negated {
+		return e.evalNot(t, iter)
+	}
+
+	return e.evalPositive(t, iter)
+}
+
+// evalPositive calls iter with each value of t other than false. Unifications
+// have the value true.
+func (e *eval) evalPositive(t *term.Term, iter func(term.Value) error) error {
+	if call, ok := t.Value.(term.Call); ok && call[0].Value.Equal(term.Op("unify")) {
+		return e.unifyTerms(call[1], call[2], func() error {
+			return iter(term.Boolean(true))
+		})
+	}
+
+	return e.evalTerm(t, func(v term.Value) error {
+		if v.Equal(term.Boolean(false)) {
+			return nil
+		}
+		return iter(v)
+	})
+}
+
+// evalNot implements negation as failure, the negated term succeeds with the
+// value true only if the term does not evaluate to a value other than false.
+func (e *eval) evalNot(t *term.Term, iter func(term.Value) error) error {
+	found := false
+	err := e.evalPositive(t, func(term.Value) error {
+		found = true
+		return nil
+	})
+	if err != nil || found {
+		return err
+	}
+	return iter(term.Boolean(true))
+}
+
+// evalSome binds the key and value of each element of the collection for the
+// duration of iter.
+func (e *eval) evalSome(some *ast.SomeDecl, iter func() error) error {
+	return e.evalTerm(some.Collection, func(coll term.Value) error {
+		return elements(coll, func(k, v term.Value) error {
+			return e.bindElement(some.Key, k, func() error {
+				return e.bindElement(some.Value, v, iter)
+			})
+		})
+	})
+}
+
+// evalEvery calls iter if the body is satisfied for each element of the
+// collection, which is trivially true for empty collections. The body is
+// evaluated in a nested scope.
+func (e *eval) evalEvery(every *ast.Every, iter func() error) error {
+	return e.evalTerm(every.Collection, func(coll term.Value) error {
+		if !isCollection(coll) {
+			return nil
+		}
+		child := e.closure()
+		all := true
+		err := elements(coll, func(k, v term.Value) error {
+			if !all {
+				return nil
+			}
+			found := false
+			err := child.bindElement(every.Key, k, func() error {
+				return child.bindElement(every.Value, v, func() error {
+					return child.evalBody(every.Body, func() error {
+						found = true
+						return nil
+					})
+				})
+			})
+			all = found
+			return err
+		})
+		if err != nil || !all {
+			return err
+		}
+		return iter()
+	})
+}
+
+// bindElement binds the variable t of some or every to x for the duration of
+// iter. Unset keys are not bound.
+func (e *eval) bindElement(t *term.Term, x term.Value, iter func() error) error {
+	if t == nil {
+		return iter()
+	}
+	return e.bindings.bind(t.Value.(term.Var), x, iter)
+}
+
+// elements calls f with the key and value of each element of coll. The key of
+// an array element is its index, the key of a set element is the element
+// itself. Scalar values have no elements.
+func elements(coll term.Value, f func(k, v term.Value) error) error {
+	switch coll := coll.(type) {
+	case term.Array:
+		for i, elem := range coll {
+			if err := f(term.Number(strconv.Itoa(i)), elem.Value); err != nil {
+				return err
+			}
+		}
+	case term.Set:
+		for _, elem := range coll {
+			if err := f(elem.Value, elem.Value); err != nil {
+				return err
+			}
+		}
+	case term.Object:
+		for _, pair := range coll {
+			if err := f(pair[0].Value, pair[1].Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isCollection(v term.Value) bool {
+	switch v.(type) {
+	case term.Array, term.Set, term.Object:
+		return true
+	}
+	return false
+}
+
+func (e *eval) evalDeclare(call term.Call, iter func() error) error {
+	v, ok := call[1].Value.(term.Var)
+	if !ok {
+		return newError(TypeErr, call[1].Location, "cannot declare %v", call[1])
+	}
+	return e.evalTerm(call[2], func(x term.Value) error {
+		return e.bindings.bind(v, x, iter)
+	})
+}
+
+// evalTerm calls iter with each value that t evaluates to. Terms containing
+// references may produce any number of values.
+func (e *eval) evalTerm(t *term.Term, iter func(term.Value) error) error {
+	switch v := t.Value.(type) {
+	case term.Var:
+		if x, ok := e.bindings.get(v); ok {
+			return iter(x)
+		}
+		if ast.IsRootDocument(v) {
+			return e.evalRef(term.Ref{t}, iter)
+		}
+		return newError(UnboundErr, t.Location, "var %v is unbound", v)
+	case term.Ref:
+		return e.evalRef(v, iter)
+	case term.Call:
+		return e.evalCall(t, v, iter)
+	case term.Array:
+		values := make([]term.Value, len(v))
+		return e.evalTerms(v, values, 0, func() error {
+			arr := make(term.Array, len(values))
+			for i, x := range values {
+				arr[i] = term.NewTerm(x)
+			}
+			return iter(arr)
+		})
+	case term.Set:
+		values := make([]term.Value, len(v))
+		return e.evalTerms(v, values, 0, func() error {
+			set := term.Set{}
+			for _, x := range values {
+				set = set.Add(term.NewTerm(x))
+			}
+			return iter(set)
+		})
+	case term.Object:
+		return e.evalObject(t, v, 0, term.Object{}, iter)
+	case *ast.ArrayComprehension:
+		return e.evalArrayComprehension(v, iter)
+	case *ast.SetComprehension:
+		return e.evalSetComprehension(v, iter)
+	case *ast.ObjectComprehension:
+		return e.evalObjectComprehension(t, v, iter)
+	default:
+		return iter(v)
+	}
+}
+
+// evalObject evaluates the key/value pairs of obj from index onwards into
+// result. Keys must be unique once evaluated.
+func (e *eval) evalObject(t *term.Term, obj term.Object, index int, result term.Object, iter func(term.Value) error) error {
+	if index == len(obj) {
+		return iter(result)
+	}
+	return e.evalTerm(obj[index][0], func(k term.Value) error {
+		return e.evalTerm(obj[index][1], func(v term.Value) error {
+			key := term.NewTerm(k)
+			if x := result.Get(key); x != nil {
+				if !x.Value.Equal(v) {
+					return newError(ConflictErr, t.Location, "object keys must be unique")
+				}
+				return e.evalObject(t, obj, index+1, result, iter)
+			}
+			return e.evalObject(t, obj, index+1, result.Insert(key, term.NewTerm(v)), iter)
+		})
+	})
+}
+
+// evalArrayComprehension evaluates the body of the comprehension in a nested
+// scope and collects the head for every way in which the body succeeds.
+func (e *eval) evalArrayComprehension(ac *ast.ArrayComprehension, iter func(term.Value) error) error {
+	arr := term.Array{}
+	child := e.closure()
+	err := child.evalBody(ac.Body, func() error {
+		return child.evalTerm(ac.Term, func(v term.Value) error {
+			arr = append(arr, term.NewTerm(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return iter(arr)
+}
+
+func (e *eval) evalSetComprehension(sc *ast.SetComprehension, iter func(term.Value) error) error {
+	set := term.Set{}
+	child := e.closure()
+	err := child.evalBody(sc.Body, func() error {
+		return child.evalTerm(sc.Term, func(v term.Value) error {
+			set = set.Add(term.NewTerm(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return iter(set)
+}
+
+// evalObjectComprehension is like evalArrayComprehension, keys must map to a
+// single value.
+func (e *eval) evalObjectComprehension(t *term.Term, oc *ast.ObjectComprehension, iter func(term.Value) error) error {
+	obj := term.Object{}
+	child := e.closure()
+	err := child.evalBody(oc.Body, func() error {
+		return child.evalTerm(oc.Key, func(k term.Value) error {
+			return child.evalTerm(oc.Value, func(v term.Value) error {
+				key := term.NewTerm(k)
+				if x := obj.Get(key); x != nil {
+					if !x.Value.Equal(v) {
+						return newError(ConflictErr, t.Location, "object keys must be unique")
+					}
+					return nil
+				}
+				obj = obj.Insert(key, term.NewTerm(v))
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return iter(obj)
+}
+
+func (e *eval) evalTerms(ts []*term.Term, values []term.Value, index int, iter func() error) error {
+	if index == len(ts) {
+		return iter()
+	}
+	return e.evalTerm(ts[index], func(v term.Value) error {
+		values[index] = v
+		return e.evalTerms(ts, values, index+1, iter)
+	})
+}
+
+func (e *eval) evalCall(t *term.Term, call term.Call, iter func(term.Value) error) error {
+	operands := make([]term.Value, len(call)-1)
+
+	op := call[0]
+	if m, ok := e.mocks[op.String()]; ok {
+		if m.op == nil {
+			return e.evalTerms(call[1:], operands, 0, func() error {
+				return iter(m.value)
+			})
+		}
+		op = m.op
+	}
+
+	if node := e.function(op); node != nil {
+		return e.evalTerms(call[1:], operands, 0, func() error {
+			return e.evalFunction(t, node, operands, iter)
+		})
+	}
+
+	fn, ok := e.builtin(op)
+	if !ok {
+		return newError(TypeErr, t.Location, "undefined function %v", op)
+	}
+
+	return e.evalTerms(call[1:], operands, 0, func() error {
+		v, err := fn(operands)
+		if err != nil {
+			return newError(BuiltinErr, t.Location, "%v: %v", op, err)
+		}
+		if v == nil {
+			return nil
+		}
+		return iter(v)
+	})
+}
+
+// function returns the rule tree node of the user function called by op or nil
+// if op does not refer to a user function.
+func (e *eval) function(op *term.Term) *compile.TreeNode {
+	ref, ok := op.Value.(term.Ref)
+	if !ok || !ref[0].Value.Equal(ast.RootDocument.Value) {
+		return nil
+	}
+	node := e.compiler.RuleTree.Lookup(ref)
+	if node == nil || len(node.Values) == 0 || !node.Values[0].IsFunction() {
+		return nil
+	}
+	return node
+}
+
+// evalFunction evaluates the user function defined at node with the operands
+// as its arguments. Every definition of the function whose arguments unify
+// with the operands is evaluated, all of them must produce the same value.
+func (e *eval) evalFunction(t *term.Term, node *compile.TreeNode, operands []term.Value, iter func(term.Value) error) error {
+	var result term.Value
+
+	if e.active[node] {
+		return newError(RecursionErr, t.Location, "function %v is recursive", node.Values[0].Name)
+	}
+	e.active[node] = true
+
+	for _, rule := range node.Values {
+		child := e.child()
+		err := child.unifyArgs(rule.Args, operands, 0, func() error {
+			return child.evalRuleChain(rule, func(_ *ast.Rule, v term.Value) error {
+				if result != nil && !result.Equal(v) {
+					return newError(ConflictErr, t.Location, "functions must not produce multiple outputs for same inputs")
+				}
+				result = v
+				return nil
+			})
+		})
+		if err != nil {
+			delete(e.active, node)
+			return err
+		}
+	}
+	delete(e.active, node)
+
+	if result == nil {
+		return nil
+	}
+	return iter(result)
+}
+
+func (e *eval) evalRef(ref term.Ref, iter func(term.Value) error) error {
+	head, ok := ref[0].Value.(term.Var)
+	if !ok {
+		return e.evalTerm(ref[0], func(v term.Value) error {
+			return e.evalRefValue(v, ref, 1, iter)
+		})
+	}
+
+	if x, ok := e.bindings.get(head); ok {
+		return e.evalRefValue(x, ref, 1, iter)
+	}
+
+	switch {
+	case ast.InputRootDocument.Value.Equal(head):
+		if e.input == nil {
+			return nil
+		}
+		return e.evalRefValue(e.input.Value, ref, 1, iter)
+	case ast.RootDocument.Value.Equal(head):
+		if e.compiler == nil || e.compiler.RuleTree == nil {
+			return nil
+		}
+		return e.evalRefRule(e.compiler.RuleTree.Child(head), ref, 1, iter)
+	}
+
+	return newError(UnboundErr, ref[0].Location, "var %v is unbound", head)
+}
+
+// evalRefValue applies the operands of ref from index onwards to the value v.
+func (e *eval) evalRefValue(v term.Value, ref term.Ref, index int, iter func(term.Value) error) error {
+	if index == len(ref) {
+		return iter(v)
+	}
+
+	operand := ref[index]
+
+	switch v := v.(type) {
+	case term.Array:
+		if x, ok := e.unboundVar(operand); ok {
+			for i := range v {
+				err := e.bindings.bind(x, term.Number(strconv.Itoa(i)), func() error {
+					return e.evalRefValue(v[i].Value, ref, index+1, iter)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return e.evalTerm(operand, func(k term.Value) error {
+			i, ok := arrayIndex(k, len(v))
+			if !ok {
+				return nil
+			}
+			return e.evalRefValue(v[i].Value, ref, index+1, iter)
+		})
+	case term.Set:
+		if x, ok := e.unboundVar(operand); ok {
+			for _, elem := range v {
+				err := e.bindings.bind(x, elem.Value, func() error {
+					return e.evalRefValue(elem.Value, ref, index+1, iter)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return e.evalTerm(operand, func(k term.Value) error {
+			if !v.Contains(term.NewTerm(k)) {
+				return nil
+			}
+			return e.evalRefValue(k, ref, index+1, iter)
+		})
+	case term.Object:
+		if x, ok := e.unboundVar(operand); ok {
+			for _, pair := range v {
+				err := e.bindings.bind(x, pair[0].Value, func() error {
+					return e.evalRefValue(pair[1].Value, ref, index+1, iter)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return e.evalTerm(operand, func(k term.Value) error {
+			x := v.Get(term.NewTerm(k))
+			if x == nil {
+				return nil
+			}
+			return e.evalRefValue(x.Value, ref, index+1, iter)
+		})
+	}
+
+	// scalar values cannot be dereferenced
+	return nil
+}
+
+// evalRefRule applies the operands of ref from index onwards to the rule tree
+// rooted at node. Once a node containing rules is reached, the rules are
+// evaluated and the remaining operands are applied to the produced value.
+func (e *eval) evalRefRule(node *compile.TreeNode, ref term.Ref, index int, iter func(term.Value) error) error {
+	if node == nil {
+		return nil
+	}
+
+	if v, ok := e.data[node]; ok {
+		return e.evalRefValue(v, ref, index, iter)
+	}
+
+	if len(node.Values) > 0 {
+		if node.Values[0].IsFunction() {
+			// functions are only evaluated when called
+			return nil
+		}
+		v, err := e.evalRule(node)
+		if err != nil || v == nil {
+			return err
+		}
+		return e.evalRefValue(v, ref, index, iter)
+	}
+
+	if index == len(ref) {
+		// packages are not values
+		return nil
+	}
+
+	operand := ref[index]
+
+	if v, ok := e.unboundVar(operand); ok {
+		for _, k := range node.Sorted() {
+			child := node.Children[k]
+			err := e.bindings.bind(v, k, func() error {
+				return e.evalRefRule(child, ref, index+1, iter)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return e.evalTerm(operand, func(k term.Value) error {
+		return e.evalRefRule(node.Child(k), ref, index+1, iter)
+	})
+}
+
+// evalRule evaluates the rules defined at node and returns the document they
+// produce or nil if the document is undefined. Values are cached for the
+// duration of the query. Recursive rules are rejected by the compiler, a rule
+// that is reached again while it is evaluated is an error nonetheless.
+func (e *eval) evalRule(node *compile.TreeNode) (term.Value, error) {
+	if v, ok := e.virtual[node]; ok {
+		return v, nil
+	}
+
+	if e.active[node] {
+		return nil, newError(RecursionErr, node.Values[0].Location, "rule %v is recursive", node.Values[0].Name)
+	}
+	e.active[node] = true
+	defer delete(e.active, node)
+
+	var result term.Value
+	var err error
+
+	switch node.Values[0].Kind() {
+	case ast.PartialSetRule:
+		result, err = e.evalPartialSet(node)
+	case ast.PartialObjectRule:
+		result, err = e.evalPartialObject(node)
+	default:
+		result, err = e.evalComplete(node)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.virtual[node] = result
+	return result, nil
+}
+
+// evalComplete returns the value produced by the complete rules at node, the
+// value of the default rule if none of them produce a value or nil if the
+// value is undefined.
+func (e *eval) evalComplete(node *compile.TreeNode) (term.Value, error) {
+	var result term.Value
+	var def *ast.Rule
+
+	for _, rule := range node.Values {
+		if rule.Default {
+			def = rule
+			continue
+		}
+		err := e.child().evalRuleChain(rule, func(rule *ast.Rule, v term.Value) error {
+			if result != nil && !result.Equal(v) {
+				return newError(ConflictErr, rule.Location, "complete rules must not produce multiple outputs")
+			}
+			result = v
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result == nil && def != nil {
+		result = def.Value.Value
+	}
+	return result, nil
+}
+
+// evalPartialSet returns the union of the keys produced by the partial set
+// rules at node. The set is empty if none of the rules produce a key.
+func (e *eval) evalPartialSet(node *compile.TreeNode) (term.Value, error) {
+	set := term.Set{}
+
+	for _, rule := range node.Values {
+		child := e.child()
+		child.rule = rule
+		err := child.evalBody(rule.Body, func() error {
+			return child.evalTerm(rule.Key, func(k term.Value) error {
+				set = set.Add(term.NewTerm(k))
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// evalPartialObject returns the union of the items produced by the partial
+// object rules at node. Every key must map to a single value.
+func (e *eval) evalPartialObject(node *compile.TreeNode) (term.Value, error) {
+	obj := term.Object{}
+
+	for _, rule := range node.Values {
+		child := e.child()
+		child.rule = rule
+		err := child.evalBody(rule.Body, func() error {
+			return child.evalTerm(rule.Key, func(k term.Value) error {
+				return child.evalTerm(rule.Value, func(v term.Value) error {
+					key := term.NewTerm(k)
+					if x := obj.Get(key); x != nil {
+						if !x.Value.Equal(v) {
+							return newError(ConflictErr, rule.Location, "object keys must be unique")
+						}
+						return nil
+					}
+					obj = obj.Insert(key, term.NewTerm(v))
+					return nil
+				})
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return obj, nil
+}
+
+// evalRuleChain calls iter with the value of rule for each way in which its
+// body is satisfied. If the body is never satisfied the rules of the else
+// chain are evaluated in order until the first one that is.
+func (e *eval) evalRuleChain(rule *ast.Rule, iter func(*ast.Rule, term.Value) error) error {
+	e.rule = rule
+	for ; rule != nil; rule = rule.Else {
+		found := false
+		err := e.evalBody(rule.Body, func() error {
+			return e.evalTerm(rule.Value, func(v term.Value) error {
+				found = true
+				return iter(rule, v)
+			})
+		})
+		if err != nil || found {
+			return err
+		}
+	}
+	return nil
+}
+
+// unboundVar returns the variable t if it is not bound in the current scope.
+func (e *eval) unboundVar(t *term.Term) (term.Var, bool) {
+	v, ok := t.Value.(term.Var)
+	if !ok {
+		return "", false
+	}
+	if ast.IsWildcard(v) {
+		return v, true
+	}
+	if _, ok := e.bindings.get(v); ok {
+		return "", false
+	}
+	return v, !ast.IsRootDocument(v)
+}
+
+// arrayIndex returns k as an index into a collection of length n.
+func arrayIndex(k term.Value, n int) (int, bool) {
+	num, ok := k.(term.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(string(num))
+	if err != nil || i < 0 || i >= n {
+		return 0, false
+	}
+	return i, true
+}
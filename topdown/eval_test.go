@@ -0,0 +1,736 @@
+package topdown
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/compile"
+	"avidbound.com/zego/ast/parser"
+	"avidbound.com/zego/ast/term"
+	"avidbound.com/zego/util"
+)
+
+func TestEvalRules(t *testing.T) {
+	module := `package test
+
+	a := input.a
+	b := c { c := a }
+	sum := x + y {
+		x := input.n
+		y := 2
+	}
+	big := true { input.n > 10 }
+	half := input.n / 4
+	first := x {
+		input.items[i] == "b"
+		x := i
+	}`
+
+	input := `{"a": "test", "n": 12, "items": ["a", "b", "c"]}`
+
+	assertEval(t, "input ref", module, input, `x := zego.test.a`, `[{"x": "test"}]`)
+	assertEval(t, "rule ref", module, input, `x := zego.test.b`, `[{"x": "test"}]`)
+	assertEval(t, "arithmetic", module, input, `x := zego.test.sum`, `[{"x": 14}]`)
+	assertEval(t, "comparison", module, input, `x := zego.test.big`, `[{"x": true}]`)
+	assertEval(t, "float", module, input, `x := zego.test.half`, `[{"x": 3}]`)
+	assertEval(t, "precedence", module, input, `x := 20 - input.n - 2 * 3 + 1`, `[{"x": 3}]`)
+	assertEval(t, "backtracking", module, input, `x := zego.test.first`, `[{"x": 1}]`)
+	assertEval(t, "undefined", module, `{"n": 1}`, `x := zego.test.big`, `[]`)
+	assertEval(t, "iteration", module, input, `x := input.items[i]`, `[{"i": 0, "x": "a"}, {"i": 1, "x": "b"}, {"i": 2, "x": "c"}]`)
+	assertEval(t, "rule iteration", module, input, `zego.test[k] == "test"`, `[{"k": "a"}, {"k": "b"}]`)
+}
+
+func TestEvalPrefixOperators(t *testing.T) {
+	module := `package test
+
+	default limit := -1
+	delta := -input.delta
+	total := -(input.a + input.b)
+	disabled := !input.enabled
+	within := true { input.delta > limit * 10 }
+	bounded := true {
+		x := input.a
+		-x < 0
+		-2 < x
+	}`
+
+	input := `{"delta": 2.5, "a": 1, "b": 2, "enabled": true}`
+
+	assertEval(t, "negative literal", module, input, `x := zego.test.limit`, `[{"x": -1}]`)
+	assertEval(t, "negate ref", module, input, `x := zego.test.delta`, `[{"x": -2.5}]`)
+	assertEval(t, "negate group", module, input, `x := zego.test.total`, `[{"x": -3}]`)
+	assertEval(t, "not", module, input, `x := zego.test.disabled`, `[{"x": false}]`)
+	assertEval(t, "negative comparison", module, input, `x := zego.test.within`, `[{"x": true}]`)
+	assertEval(t, "double negate", module, input, `x := --input.a`, `[{"x": 1}]`)
+	assertEval(t, "line starts with prefix operator", module, input, `x := zego.test.bounded`, `[{"x": true}]`)
+	assertEvalError(t, "negate type", module, input, `x := -input.enabled`, BuiltinErr)
+	assertEvalError(t, "not type", module, input, `x := !input.a`, BuiltinErr)
+}
+
+func TestEvalNull(t *testing.T) {
+	module := `package test
+
+	missing := input.a == null
+	value := input.a`
+
+	assertEval(t, "null input", module, `{"a": null}`, `x := zego.test.missing`, `[{"x": true}]`)
+	assertEval(t, "non-null input", module, `{"a": 1}`, `x := zego.test.missing`, `[{"x": false}]`)
+	assertEval(t, "null value", module, `{"a": null}`, `x := zego.test.value`, `[{"x": null}]`)
+	assertEval(t, "null literal", module, `{}`, `x := null`, `[{"x": null}]`)
+}
+
+func TestEvalArray(t *testing.T) {
+	module := `package test
+
+	arr := [1, input.x, [input.x]]
+	second := arr[1]
+	nested := arr[2][0]
+	roles := input.roles`
+
+	input := `{"x": "a", "roles": ["admin", "dev"]}`
+
+	assertEval(t, "literal", module, input, `x := zego.test.arr`, `[{"x": [1, "a", ["a"]]}]`)
+	assertEval(t, "index", module, input, `x := zego.test.second`, `[{"x": "a"}]`)
+	assertEval(t, "nested index", module, input, `x := zego.test.nested`, `[{"x": "a"}]`)
+	assertEval(t, "out of range", module, input, `x := zego.test.arr[3]`, `[]`)
+	assertEval(t, "input", module, input, `x := zego.test.roles`, `[{"x": ["admin", "dev"]}]`)
+	assertEval(t, "iteration", module, input, `zego.test.arr[i] == "a"`, `[{"i": 1}]`)
+	assertEval(t, "equality", module, input, `x := [1, 2] == [1, 2]`, `[{"x": true}]`)
+	assertEval(t, "literal index", module, input, `x := [1, 2][1]`, `[{"x": 2}]`)
+}
+
+func TestEvalObject(t *testing.T) {
+	module := `package test
+
+	decision := {"allow": allowed, "user": input.user.name} {
+		allowed := input.user.role == "admin"
+	}
+	role := input.user["role"]`
+
+	input := `{"user": {"name": "bob", "role": "admin", "teams": {"a": 1, "b": 2}}}`
+
+	assertEval(t, "literal", module, input, `x := zego.test.decision`, `[{"x": {"allow": true, "user": "bob"}}]`)
+	assertEval(t, "index", module, input, `x := zego.test.decision.user`, `[{"x": "bob"}]`)
+	assertEval(t, "input", module, input, `x := zego.test.role`, `[{"x": "admin"}]`)
+	assertEval(t, "missing key", module, input, `x := input.user.missing`, `[]`)
+	assertEval(t, "iteration", module, input, `v := input.user.teams[k]`, `[{"k": "a", "v": 1}, {"k": "b", "v": 2}]`)
+	assertEval(t, "key order", module, input, `x := {"b": 1, "a": 2} == {"a": 2, "b": 1}`, `[{"x": true}]`)
+	assertEvalError(t, "conflicting keys", module, input, `x := {input.user.name: 1, "bob": 2}`, ConflictErr)
+}
+
+func TestEvalSet(t *testing.T) {
+	module := `package test
+
+	granted := {"read", "write", input.extra}
+	required := {"read", "admin"}
+	common := granted & required
+	all := granted | required
+	missing := required - granted
+	empty := (missing - missing) == set()`
+
+	input := `{"extra": "read"}`
+
+	assertEval(t, "literal", module, input, `x := zego.test.granted`, `[{"x": ["read", "write"]}]`)
+	assertEval(t, "intersection", module, input, `x := zego.test.common`, `[{"x": ["read"]}]`)
+	assertEval(t, "union", module, input, `x := zego.test.all`, `[{"x": ["admin", "read", "write"]}]`)
+	assertEval(t, "difference", module, input, `x := zego.test.missing`, `[{"x": ["admin"]}]`)
+	assertEval(t, "empty", module, input, `x := zego.test.empty`, `[{"x": true}]`)
+	assertEval(t, "membership", module, input, `x := zego.test.granted["write"]`, `[{"x": "write"}]`)
+	assertEval(t, "non-member", module, input, `x := zego.test.granted["admin"]`, `[]`)
+	assertEval(t, "iteration", module, input, `zego.test.required[x]`, `[{"x": "admin"}, {"x": "read"}]`)
+	assertEval(t, "canonical", module, input, `x := {2, 1, 1} == {1, 2}`, `[{"x": true}]`)
+	assertEvalError(t, "type error", module, input, `x := {1} & [1]`, BuiltinErr)
+}
+
+func TestEvalComprehension(t *testing.T) {
+	module := `package test
+
+	enabled := [u.name | u := input.users[_]; u.enabled]
+	names := {u.name | u := input.users[_]}
+	by_name := {u.name: i | u := input.users[i]}
+	none := [x | x := input.users[_]; x.name == "nobody"]
+	nested := [[i | input.users[i].name == n] | n := ["a", "c"][_]]
+	outer := [x | x := y + input.users[_].id] { y := 10 }`
+
+	input := `{"users": [{"name": "a", "id": 1, "enabled": true}, {"name": "b", "id": 2, "enabled": false}, {"name": "a", "id": 3, "enabled": true}]}`
+
+	assertEval(t, "array", module, input, `x := zego.test.enabled`, `[{"x": ["a", "a"]}]`)
+	assertEval(t, "set", module, input, `x := zego.test.names`, `[{"x": ["a", "b"]}]`)
+	assertEval(t, "empty", module, input, `x := zego.test.none`, `[{"x": []}]`)
+	assertEval(t, "nested", module, input, `x := zego.test.nested`, `[{"x": [[0, 2], []]}]`)
+	assertEval(t, "outer scope", module, input, `x := zego.test.outer`, `[{"x": [11, 12, 13]}]`)
+	assertEval(t, "query", module, input, `x := {i | input.users[i].enabled}`, `[{"x": [0, 2]}]`)
+	assertEval(t, "scope", module, input, `x := [i | input.users[i]]; i := 1`, `[{"i": 1, "x": [0, 1, 2]}]`)
+	assertEvalError(t, "object conflict", module, input, `x := zego.test.by_name`, ConflictErr)
+	assertEval(t, "object", module, input, `x := {u.name: u.id | u := input.users[_]; u.enabled == false}`, `[{"x": {"b": 2}}]`)
+}
+
+func TestEvalUnsafe(t *testing.T) {
+	tests := []struct {
+		note     string
+		query    string
+		expected []string
+	}{
+		{"unbound", `x := y`, []string{"var y is unsafe"}},
+		{"repeated", `y > 1; y < 3`, []string{"var y is unsafe"}},
+		{"comprehension", `x := [y | y > 1]`, []string{"var y is unsafe"}},
+		{"object comprehension", `x := {k: v | k > 1}`, []string{"var k is unsafe", "var v is unsafe"}},
+	}
+
+	for _, tc := range tests {
+		body, err := parser.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("parse query: %v", err)
+		}
+
+		_, err = compile.NewCompiler().NewQueryCompiler().Compile(body)
+		errs, ok := err.(ast.Errors)
+		if !ok || len(errs) != len(tc.expected) {
+			t.Errorf("Error on test \"%s\": expected %d errors but got: %v", tc.note, len(tc.expected), err)
+			continue
+		}
+		for i, e := range errs {
+			if !strings.Contains(e.Error(), tc.expected[i]) {
+				t.Errorf("Error on test \"%s\": expected %q but got: %v", tc.note, tc.expected[i], e)
+			}
+		}
+	}
+}
+
+func TestEvalFunction(t *testing.T) {
+	module := `package test
+
+	is_admin(user) := true { user.role == "admin" }
+	double(x) := x * 2
+	sum([a, b]) := a + b
+	greet({"name": name}) := concat { concat := name }
+	sign(x) := "negative" { x < 0 }
+	sign(x) := "positive" { x > 0 }
+	sign(0) := "zero"
+	pick(x) := x { x > 0 }
+	pick(x) := 0 { x > 0 }
+	answer() := 42
+
+	admins := {u.name | u := input.users[_]; is_admin(u)}
+	total := double(sum([input.a, 2]))`
+
+	input := `{"a": 1, "users": [{"name": "a", "role": "admin"}, {"name": "b", "role": "dev"}]}`
+
+	assertEval(t, "call", module, input, `x := zego.test.is_admin(input.users[0])`, `[{"x": true}]`)
+	assertEval(t, "undefined", module, input, `x := zego.test.is_admin(input.users[1])`, `[]`)
+	assertEval(t, "same package", module, input, `x := zego.test.admins`, `[{"x": ["a"]}]`)
+	assertEval(t, "nested calls", module, input, `x := zego.test.total`, `[{"x": 6}]`)
+	assertEval(t, "array argument", module, input, `x := zego.test.sum([1, 2])`, `[{"x": 3}]`)
+	assertEval(t, "array mismatch", module, input, `x := zego.test.sum([1, 2, 3])`, `[]`)
+	assertEval(t, "object argument", module, input, `x := zego.test.greet({"name": "z"})`, `[{"x": "z"}]`)
+	assertEval(t, "constant argument", module, input, `x := zego.test.sign(0)`, `[{"x": "zero"}]`)
+	assertEval(t, "multiple definitions", module, input, `x := zego.test.sign(2)`, `[{"x": "positive"}]`)
+	assertEval(t, "no arguments", module, input, `x := zego.test.answer()`, `[{"x": 42}]`)
+	assertEval(t, "iteration", module, input, `x := zego.test.double([1, 2][i])`, `[{"i": 0, "x": 2}, {"i": 1, "x": 4}]`)
+	assertEval(t, "not iterated", module, input, `zego.test[k] == 42`, `[]`)
+	assertEvalError(t, "conflict", module, input, `x := zego.test.pick(1)`, ConflictErr)
+}
+
+func TestEvalElse(t *testing.T) {
+	module := `package test
+
+	tier := "gold" { input.spend > 1000 } else := "silver" { input.spend > 100 } else := "bronze"
+	first := x { x := input.items[_]; x > 1 } else := 0
+	undefined := 1 { input.spend > 1000 } else := 2 { input.spend > 100 }
+	size(x) := "big" { x > 10 } else := "small"`
+
+	assertEval(t, "first", module, `{"spend": 2000}`, `x := zego.test.tier`, `[{"x": "gold"}]`)
+	assertEval(t, "second", module, `{"spend": 200}`, `x := zego.test.tier`, `[{"x": "silver"}]`)
+	assertEval(t, "last", module, `{"spend": 20}`, `x := zego.test.tier`, `[{"x": "bronze"}]`)
+	assertEval(t, "undefined", module, `{"spend": 20}`, `x := zego.test.undefined`, `[]`)
+	assertEval(t, "function", module, `{}`, `x := zego.test.size(11); y := zego.test.size(1)`, `[{"x": "big", "y": "small"}]`)
+	assertEval(t, "fallback", module, `{"items": [0, 1]}`, `x := zego.test.first`, `[{"x": 0}]`)
+	assertEvalError(t, "conflict", module, `{"items": [2, 3]}`, `x := zego.test.first`, ConflictErr)
+}
+
+func TestEvalDefault(t *testing.T) {
+	module := `package test
+
+	default allow := false
+	allow := true { input.user == "admin" }
+
+	default roles := ["guest"]
+	roles := input.roles`
+
+	assertEval(t, "rule", module, `{"user": "admin"}`, `x := zego.test.allow`, `[{"x": true}]`)
+	assertEval(t, "default", module, `{"user": "bob"}`, `x := zego.test.allow`, `[{"x": false}]`)
+	assertEval(t, "missing input", module, `{}`, `x := zego.test.allow`, `[{"x": false}]`)
+	assertEval(t, "composite", module, `{}`, `x := zego.test.roles[0]`, `[{"x": "guest"}]`)
+	assertEval(t, "overridden", module, `{"roles": ["dev"]}`, `x := zego.test.roles[0]`, `[{"x": "dev"}]`)
+
+	assertCompileError(t, "multiple", []string{"package test\ndefault a := 1\ndefault a := 2"}, "multiple default rules a found")
+	assertCompileError(t, "non-ground", []string{"package test\ndefault a := input.a"}, "default rule a value must be a ground constant")
+	assertCompileError(t, "rule ref", []string{"package test\nb := 1\ndefault a := [b]"}, "default rule a value must be a ground constant")
+}
+
+func TestEvalPartial(t *testing.T) {
+	module := `package test
+
+	deny contains msg {
+		user := input.users[_]
+		user.age < 18
+		msg := user.name
+	}
+	deny contains "no users" { input.users == [] }
+	deny contains "always"
+
+	ages[name] := age {
+		user := input.users[_]
+		name := user.name
+		age := user.age
+	}
+	ages["root"] := 0
+
+	clash[k] := v { v := input.users[_].age; k := "age" }`
+
+	input := `{"users": [{"name": "a", "age": 12}, {"name": "b", "age": 30}, {"name": "c", "age": 15}]}`
+
+	assertEval(t, "set", module, input, `x := zego.test.deny`, `[{"x": ["a", "always", "c"]}]`)
+	assertEval(t, "set union", module, `{"users": []}`, `x := zego.test.deny`, `[{"x": ["always", "no users"]}]`)
+	assertEval(t, "member", module, input, `x := zego.test.deny["a"]`, `[{"x": "a"}]`)
+	assertEval(t, "non-member", module, input, `x := zego.test.deny["b"]`, `[]`)
+	assertEval(t, "members", module, input, `zego.test.deny[msg]`, `[{"msg": "a"}, {"msg": "always"}, {"msg": "c"}]`)
+	assertEval(t, "object", module, input, `x := zego.test.ages`, `[{"x": {"a": 12, "b": 30, "c": 15, "root": 0}}]`)
+	assertEval(t, "object key", module, input, `x := zego.test.ages.b`, `[{"x": 30}]`)
+	assertEval(t, "object items", module, input, `zego.test.ages[k] < 13`, `[{"k": "a"}, {"k": "root"}]`)
+	assertEvalError(t, "object conflict", module, input, `x := zego.test.clash`, ConflictErr)
+
+	assertCompileError(t, "kind conflict", []string{"package test\na contains 1\na := 1"}, "complete rule a conflicts with partial set rule a")
+	assertCompileError(t, "package conflict", []string{"package test\na contains 1", "package test.a\nb := 1"}, "partial set rule a conflicts with package")
+	assertCompileError(t, "unsafe key", []string{"package test\na contains x { true }"}, "var x is unsafe")
+}
+
+func TestEvalNot(t *testing.T) {
+	module := `package test
+
+	default allow := false
+	allow := true {
+		not zego.test.blocked[input.user]
+	}
+	blocked := {"mallory", "eve"}
+
+	no_admins := true { not input.roles[_] == "admin" }
+	empty := true { not input.missing }
+	falsy := true { not input.flag }`
+
+	assertEval(t, "allowed", module, `{"user": "alice"}`, `x := zego.test.allow`, `[{"x": true}]`)
+	assertEval(t, "blocked", module, `{"user": "eve"}`, `x := zego.test.allow`, `[{"x": false}]`)
+	assertEval(t, "iteration", module, `{"roles": ["dev", "ops"]}`, `x := zego.test.no_admins`, `[{"x": true}]`)
+	assertEval(t, "iteration match", module, `{"roles": ["dev", "admin"]}`, `x := zego.test.no_admins`, `[]`)
+	assertEval(t, "undefined", module, `{}`, `x := zego.test.empty`, `[{"x": true}]`)
+	assertEval(t, "false", module, `{"flag": false}`, `x := zego.test.falsy`, `[{"x": true}]`)
+	assertEval(t, "true", module, `{"flag": true}`, `x := zego.test.falsy`, `[]`)
+	assertEval(t, "query", module, `{}`, `not zego.test.blocked["alice"]`, `[{}]`)
+
+	assertCompileError(t, "unbound", []string{"package test\na := true { not input.x[y] }"}, "var y is unsafe")
+	assertCompileError(t, "bound after", []string{"package test\na := true { not input.x[y]; y := 1 }"}, "var y is unsafe")
+}
+
+func TestEvalSome(t *testing.T) {
+	module := `package test
+
+	names contains name { some user in input.users; name := user.name }
+	indexes contains i { some i, user in input.users; user.admin }
+	headers[k] := v { some k, v in input.headers; k != "secret" }
+	tags contains [k, v] { some k, v in {"a", "b"} }
+	admin := true { "admin" in input.roles }
+	has_get := true { "GET" in {"GET", "POST"} }
+	has_value := true { "json" in input.headers }`
+
+	input := `{"users": [{"name": "a", "admin": true}, {"name": "b"}], "roles": ["dev", "admin"], "headers": {"type": "json", "secret": "x"}}`
+
+	assertEval(t, "array", module, input, `x := zego.test.names`, `[{"x": ["a", "b"]}]`)
+	assertEval(t, "array index", module, input, `x := zego.test.indexes`, `[{"x": [0]}]`)
+	assertEval(t, "object", module, input, `x := zego.test.headers`, `[{"x": {"type": "json"}}]`)
+	assertEval(t, "set", module, input, `x := zego.test.tags`, `[{"x": [["a", "a"], ["b", "b"]]}]`)
+	assertEval(t, "query", module, input, `some x in [1, 2]; x > 1`, `[{"x": 2}]`)
+	assertEval(t, "scalar", module, input, `some x in 1`, `[]`)
+	assertEval(t, "member array", module, input, `x := zego.test.admin`, `[{"x": true}]`)
+	assertEval(t, "member set", module, input, `x := zego.test.has_get`, `[{"x": true}]`)
+	assertEval(t, "member object", module, input, `x := zego.test.has_value`, `[{"x": true}]`)
+	assertEval(t, "non-member", module, input, `x := "root" in input.roles`, `[{"x": false}]`)
+	assertEvalError(t, "member type", module, input, `x := 1 in 1`, BuiltinErr)
+
+	assertCompileError(t, "declared above", []string{"package test\na := true { x := 1; some x in [1] }"}, "var x declared above")
+	assertCompileError(t, "unsafe collection", []string{"package test\na := true { some x in y }"}, "var y is unsafe")
+}
+
+func TestEvalEvery(t *testing.T) {
+	module := `package test
+
+	all_named := true { every user in input.users { user.name != "" } }
+	all_admin := true { every user in input.users { user.admin } }
+	none := true { every x in [] { false } }
+	indexed := true { every i, user in input.users { i < 2; user.name } }
+	headers := true { every k, v in input.headers { k != ""; v != "" } }
+	scalar := true { every x in 1 { true } }
+	local := x { x := "outer"; every y in [1] { z := y; z != x } }`
+
+	input := `{"users": [{"name": "a", "admin": true}, {"name": "b"}], "headers": {"type": "json"}}`
+
+	assertEval(t, "all hold", module, input, `x := zego.test.all_named`, `[{"x": true}]`)
+	assertEval(t, "one fails", module, input, `x := zego.test.all_admin`, `[]`)
+	assertEval(t, "empty", module, input, `x := zego.test.none`, `[{"x": true}]`)
+	assertEval(t, "key value", module, input, `x := zego.test.indexed`, `[{"x": true}]`)
+	assertEval(t, "object", module, input, `x := zego.test.headers`, `[{"x": true}]`)
+	assertEval(t, "scalar", module, input, `x := zego.test.scalar`, `[]`)
+	assertEval(t, "query", module, input, `every x in [1, 2] { x > 0 }`, `[{}]`)
+	assertEval(t, "outer var", module, input, `x := zego.test.local`, `[{"x": "outer"}]`)
+
+	assertCompileError(t, "scoped", []string{"package test\na := x { every x in [1] { true } }"}, "var x is unsafe")
+	assertCompileError(t, "unsafe body", []string{"package test\na := true { every x in [1] { x == y } }"}, "var y is unsafe")
+	assertCompileError(t, "declared above", []string{"package test\na := true { x := 1; every x in [1] { true } }"}, "var x declared above")
+}
+
+func TestEvalUnify(t *testing.T) {
+	module := `package test
+
+	pair := [a, b] { [a, b] = input.pair }
+	reversed := [a, b] { input.pair = [a, b] }
+	nested := x { {"user": {"name": x}, "ok": true} = input.doc }
+	mixed := [a, b] { [a, 2] = [1, b] }
+	compare := true { x := 1; x = 1 }
+	mismatch := true { [a, b] = [1] }
+	refs := [i, j] { input.pair[i] = input.pair[j]; i != j }
+	negated := true { x := 2; not x = 1 }`
+
+	input := `{"pair": [1, 2], "doc": {"user": {"name": "a"}, "ok": true}}`
+
+	assertEval(t, "array", module, input, `x := zego.test.pair`, `[{"x": [1, 2]}]`)
+	assertEval(t, "reversed", module, input, `x := zego.test.reversed`, `[{"x": [1, 2]}]`)
+	assertEval(t, "nested object", module, input, `x := zego.test.nested`, `[{"x": "a"}]`)
+	assertEval(t, "both sides", module, input, `x := zego.test.mixed`, `[{"x": [1, 2]}]`)
+	assertEval(t, "compare", module, input, `x := zego.test.compare`, `[{"x": true}]`)
+	assertEval(t, "mismatch", module, input, `x := zego.test.mismatch`, `[]`)
+	assertEval(t, "refs", module, input, `x := zego.test.refs`, `[]`)
+	assertEval(t, "negated", module, input, `x := zego.test.negated`, `[{"x": true}]`)
+	assertEval(t, "query", module, input, `[x, {"b": y}] = [1, {"b": 2}]`, `[{"x": 1, "y": 2}]`)
+
+	assertCompileError(t, "both unsafe", []string{"package test\na := true { x = y }"}, "var x is unsafe")
+	assertCompileError(t, "unsafe call", []string{"package test\na := true { [x] = [y + 1] }"}, "var y is unsafe")
+	assertCompileError(t, "unsafe negated", []string{"package test\na := true { not x = 1 }"}, "var x is unsafe")
+}
+
+func TestEvalWith(t *testing.T) {
+	module := `package test
+
+	allow := true { input.user.role == "admin" }
+	admin := x { x := allow with input.user as {"role": "admin"} }
+	guest := x { x := allow with input.user.role as "guest" }
+	kept := x { x := input.user.name with input.user.role as "admin" }
+	unmodified := x { y := allow with input.user as {"role": "admin"}; x := allow }
+
+	config := {"debug": false, "level": 1}
+	debug := x { x := config.debug }
+	replaced := x { x := debug with config as {"debug": true} }
+	patched := x { x := [debug, config.level] with config.debug as true }
+
+	mock_now() := 42
+	now := time.now_ns()
+	mocked := x { x := now with time.now_ns as mock_now }
+	constant := x { x := now with time.now_ns as 7 }
+
+	double(x) := x * 2
+	triple(x) := x * 3
+	swapped := x { x := double(2) with double as triple }
+	stubbed := x { x := double(2) with double as 0 }`
+
+	input := `{"user": {"role": "dev", "name": "a"}}`
+
+	assertEval(t, "input", module, input, `x := zego.test.admin`, `[{"x": true}]`)
+	assertEval(t, "input path", module, input, `x := zego.test.guest`, `[]`)
+	assertEval(t, "input kept", module, input, `x := zego.test.kept`, `[{"x": "a"}]`)
+	assertEval(t, "expression only", module, input, `x := zego.test.unmodified`, `[]`)
+	assertEval(t, "document", module, input, `x := zego.test.replaced`, `[{"x": true}]`)
+	assertEval(t, "document path", module, input, `x := zego.test.patched`, `[{"x": [true, 1]}]`)
+	assertEval(t, "builtin function", module, input, `x := zego.test.mocked`, `[{"x": 42}]`)
+	assertEval(t, "builtin value", module, input, `x := zego.test.constant`, `[{"x": 7}]`)
+	assertEval(t, "user function", module, input, `x := zego.test.swapped`, `[{"x": 6}]`)
+	assertEval(t, "user value", module, input, `x := zego.test.stubbed`, `[{"x": 0}]`)
+	assertEval(t, "query", module, input, `zego.test.allow with input as {"user": {"role": "admin"}}`, `[{}]`)
+	assertEval(t, "query value", module, input, `y := "admin"; x := zego.test.allow with input.user.role as y`, `[{"x": true, "y": "admin"}]`)
+
+	assertCompileError(t, "unknown document", []string{"package test\na := true { input.x with zego.test.b as 1 }"}, "with target zego.test.b must refer to input, a document or a function")
+	assertCompileError(t, "unknown root", []string{"package test\na := true { input.x with foo.bar as 1 }"}, "with target foo.bar must refer to input, a document or a function")
+	assertCompileError(t, "unknown function", []string{"package test\na := true { input.x with undefined_fn as 1 }"}, "with target undefined_fn must refer to input, a document or a function")
+	assertCompileError(t, "misspelled builtin", []string{"package test\na := true { input.x with time.now as 1 }"}, "with target time.now must refer to input, a document or a function")
+	assertCompileError(t, "base document", []string{"package test\na := true { input.x with zego.config as {} }"}, "with target zego.config must refer to input, a document or a function")
+	assertEval(t, "metadata function", module, input, `x := metadata.rule() with metadata.rule as {"title": "mock"}`, `[{"x": {"title": "mock"}}]`)
+	assertCompileError(t, "unsafe value", []string{"package test\na := true { input.x with input.y as z }"}, "var z is unsafe")
+	assertCompileError(t, "unsafe reference", []string{"package test\na := true { input.x with input as foo.bar }"}, "var foo is unsafe")
+}
+
+func TestEvalMetadata(t *testing.T) {
+	lib := `
+	# METADATA
+	# title: Library
+	# scope: subpackages
+	package lib`
+
+	module := `
+	# METADATA
+	# title: Policies
+	package lib.test
+
+	# METADATA
+	# title: Allow
+	# custom:
+	#   control: AC-2
+	allow := metadata.rule().custom.control
+
+	# METADATA
+	# title: Deny
+	# scope: document
+	deny contains x { x := metadata.rule().title }
+
+	# METADATA
+	# title: First
+	first := 1 { false } else := x { x := metadata.rule().title }
+
+	# METADATA
+	# title: Scopes
+	scopes := [x | some c in metadata.chain(); x := c.annotations.scope]
+	paths := [c.path | some c in metadata.chain()]
+
+	# METADATA
+	# title: Function
+	f(x) := metadata.rule().title
+	plain := metadata.rule()`
+
+	modules := []string{lib, module}
+
+	assertEvalModules(t, "rule", modules, `{}`, `x := zego.lib.test.allow`, `[{"x": "AC-2"}]`)
+	assertEvalModules(t, "document", modules, `{}`, `x := zego.lib.test.deny`, `[{"x": ["Deny"]}]`)
+	assertEvalModules(t, "else", modules, `{}`, `x := zego.lib.test.first`, `[{"x": "First"}]`)
+	assertEvalModules(t, "chain", modules, `{}`, `x := zego.lib.test.scopes`, `[{"x": ["rule", "package", "subpackages"]}]`)
+	assertEvalModules(t, "chain paths", modules, `{}`, `x := zego.lib.test.paths`, `[{"x": [["zego", "lib", "test"], ["zego", "lib"]]}]`)
+	assertEvalModules(t, "function", modules, `{}`, `x := zego.lib.test.f(1)`, `[{"x": "Function"}]`)
+	assertEvalModules(t, "no annotations", modules, `{}`, `x := zego.lib.test.plain`, `[{"x": {}}]`)
+	assertEvalModules(t, "query", modules, `{}`, `x := metadata.rule(); y := metadata.chain()`, `[{"x": {}, "y": []}]`)
+
+	assertCompileError(t, "duplicate document", []string{"package test\n# METADATA\n# scope: document\na := 1\n# METADATA\n# scope: document\na := 2 { false }"}, "duplicate document annotations for zego.test.a")
+	assertCompileError(t, "duplicate package", []string{"# METADATA\n# title: a\npackage test", "# METADATA\n# title: b\npackage test"}, "duplicate package annotations for zego.test")
+}
+
+func TestEvalImport(t *testing.T) {
+	lib := `package lib.users
+
+	is_admin(user) := true { user.role == "admin" }
+	names := [u.name | u := input.users[_]]`
+
+	module := `package test
+
+	import zego.lib.users
+	import zego.lib.users.names as all
+	import input.request as req
+	import input
+
+	allow := users.is_admin(req.user)
+	first := all[0]
+	method := m { m := req.method }
+	local := req { req := "local" }
+	root := input.request.method`
+
+	in := `{"users": [{"name": "a"}], "request": {"method": "GET", "user": {"role": "admin"}}}`
+	modules := []string{lib, module}
+
+	assertEvalModules(t, "package import", modules, in, `x := zego.test.allow`, `[{"x": true}]`)
+	assertEvalModules(t, "rule import", modules, in, `x := zego.test.first`, `[{"x": "a"}]`)
+	assertEvalModules(t, "input alias", modules, in, `x := zego.test.method`, `[{"x": "GET"}]`)
+	assertEvalModules(t, "shadowed", modules, in, `x := zego.test.local`, `[{"x": "local"}]`)
+	assertEvalModules(t, "input", modules, in, `x := zego.test.root`, `[{"x": "GET"}]`)
+
+	assertCompileError(t, "unknown", []string{"package test\nimport zego.lib\na := 1"}, "unknown import zego.lib")
+	assertCompileError(t, "conflicting aliases", []string{"package test\nimport input.a as x\nimport input.b as x\na := 1"}, "conflicts with import input.a as x")
+	assertCompileError(t, "conflicting rule", []string{"package test\nimport input.a\na := 1"}, "conflicts with rule a")
+	assertCompileError(t, "root alias", []string{"package test\nimport input.a as zego\na := 1"}, "cannot shadow zego")
+}
+
+func TestEvalConflict(t *testing.T) {
+	module := `package test
+
+	a := input.items[_]`
+
+	assertEvalError(t, "conflict", module, `{"items": [1, 2]}`, `x := zego.test.a`, ConflictErr)
+	assertEvalError(t, "divide by zero", module, `{}`, `x := 1 / 0`, BuiltinErr)
+}
+
+func TestEvalRecursion(t *testing.T) {
+	tests := []struct {
+		note     string
+		module   string
+		query    string
+		expected string
+	}{
+		{"direct", "package test\na := a", `x := zego.test.a`, "rule zego.test.a is recursive: zego.test.a -> zego.test.a"},
+		{"mutual", "package test\na := b\nb := c { c := a }", `x := zego.test.a`, "rule zego.test.a is recursive: zego.test.a -> zego.test.b -> zego.test.a"},
+		{"function", "package test\nf(x) := y { y := g(x) }\ng(x) := f(x)", `x := zego.test.f(1)`, "function zego.test.f is recursive: zego.test.f -> zego.test.g -> zego.test.f"},
+		{"iteration", "package test\np := x { zego.test[x] }", `x := zego.test.p`, "rule zego.test.p is recursive: zego.test.p -> zego.test.p"},
+	}
+
+	for _, tc := range tests {
+		mod, err := parser.ParseModule("test.zego", tc.module)
+		if err != nil {
+			t.Fatalf("parse module: %v", err)
+		}
+
+		c := compile.NewCompiler()
+		c.Compile(map[string]*ast.Module{"test.zego": mod})
+		if len(c.Errors) != 1 || !strings.Contains(c.Errors[0].Error(), tc.expected) {
+			t.Errorf("Error on test \"%s\": expected compile error %q but got: %v", tc.note, tc.expected, c.Errors)
+		}
+
+		// rules that are not rejected fail to evaluate rather than recurse
+		// until the stack is exhausted
+		body, err := parser.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("parse query: %v", err)
+		}
+		_, err = NewQuery(body).WithCompiler(c).Run(context.Background())
+		if e, ok := err.(*Error); !ok || e.Code != RecursionErr {
+			t.Errorf("Error on test \"%s\": expected %v but got: %v", tc.note, RecursionErr, err)
+		}
+	}
+
+	module := `package test
+
+	a := b
+	b := 1
+	c := zego.test.a + zego.test.b
+	f(x) := x + 1
+	g(x) := f(f(x))
+	d := x { x := b with d as 2 }`
+
+	assertEval(t, "shared dependency", module, `{}`, `x := zego.test.c`, `[{"x": 2}]`)
+	assertEval(t, "nested calls", module, `{}`, `x := zego.test.g(1)`, `[{"x": 3}]`)
+	assertEval(t, "with target", module, `{}`, `x := zego.test.d`, `[{"x": 1}]`)
+}
+
+func runQuery(t *testing.T, modules []string, input, query string) ([]QueryResult, error) {
+	t.Helper()
+
+	c := compileModules(t, modules)
+	if c.Failed() {
+		t.Fatalf("compile module: %v", c.Errors)
+	}
+
+	body, err := parser.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("parse query: %v", err)
+	}
+
+	compiled, err := c.NewQueryCompiler().Compile(body)
+	if err != nil {
+		t.Fatalf("compile query: %v", err)
+	}
+
+	var doc interface{}
+	if err := util.UnmarshalJSON([]byte(input), &doc); err != nil {
+		t.Fatalf("input: %v", err)
+	}
+
+	v, err := term.InterfaceToValue(doc)
+	if err != nil {
+		t.Fatalf("input: %v", err)
+	}
+
+	return NewQuery(compiled).WithCompiler(c).WithInput(term.NewTerm(v)).Run(context.Background())
+}
+
+func compileModules(t *testing.T, modules []string) *compile.Compiler {
+	t.Helper()
+
+	mods := map[string]*ast.Module{}
+	for i, module := range modules {
+		name := fmt.Sprintf("test%d.zego", i)
+		mod, err := parser.ParseModule(name, module)
+		if err != nil {
+			t.Fatalf("parse module: %v", err)
+		}
+		mods[name] = mod
+	}
+
+	c := compile.NewCompiler()
+	c.Compile(mods)
+	return c
+}
+
+func assertEval(t *testing.T, msg, module, input, query, expected string) {
+	t.Helper()
+	assertEvalModules(t, msg, []string{module}, input, query, expected)
+}
+
+func assertEvalModules(t *testing.T, msg string, modules []string, input, query, expected string) {
+	t.Helper()
+
+	qrs, err := runQuery(t, modules, input, query)
+	if err != nil {
+		t.Errorf("Error on test \"%s\": eval error: %v", msg, err)
+		return
+	}
+
+	var want []map[string]interface{}
+	if err := util.UnmarshalJSON([]byte(expected), &want); err != nil {
+		t.Fatalf("Error on test \"%s\": expected: %v", msg, err)
+	}
+
+	if len(qrs) != len(want) {
+		t.Errorf("Error on test \"%s\": expected %d results but got %d: %v", msg, len(want), len(qrs), qrs)
+		return
+	}
+
+	for i, qr := range qrs {
+		if len(qr.Bindings) != len(want[i]) {
+			t.Errorf("Error on test \"%s\": result %d: expected %v but got %v", msg, i, want[i], qr)
+			continue
+		}
+		for k, v := range want[i] {
+			x, err := term.InterfaceToValue(v)
+			if err != nil {
+				t.Fatalf("Error on test \"%s\": expected: %v", msg, err)
+			}
+			b, ok := qr.Bindings[term.Var(k)]
+			if !ok {
+				t.Errorf("Error on test \"%s\": result %d: expected %v = %v but it is not bound", msg, i, k, x)
+				continue
+			}
+			// compare the JSON representation, e.g. sets are represented as arrays
+			native, err := term.ValueToInterface(b.Value)
+			if err != nil {
+				t.Fatalf("Error on test \"%s\": result: %v", msg, err)
+			}
+			if y, _ := term.InterfaceToValue(native); y.Compare(x) != 0 {
+				t.Errorf("Error on test \"%s\": result %d: expected %v = %v but got %v", msg, i, k, x, b)
+			}
+		}
+	}
+}
+
+func assertEvalError(t *testing.T, msg, module, input, query, code string) {
+	t.Helper()
+
+	_, err := runQuery(t, []string{module}, input, query)
+	if e, ok := err.(*Error); !ok || e.Code != code {
+		t.Errorf("Error on test \"%s\": expected %v but got: %v", msg, code, err)
+	}
+}
+
+func assertCompileError(t *testing.T, msg string, modules []string, expected string) {
+	t.Helper()
+
+	c := compileModules(t, modules)
+	for _, err := range c.Errors {
+		if strings.Contains(err.Error(), expected) {
+			return
+		}
+	}
+	t.Errorf("Error on test \"%s\": expected compile error %q but got: %v", msg, expected, c.Errors)
+}
@@ -0,0 +1,35 @@
+package topdown
+
+import (
+	"fmt"
+
+	"avidbound.com/zego/ast/term"
+)
+
+// builtinMember returns true if the first operand is an element of the array
+// or set, or a value of the object given as the second operand.
+func builtinMember(operands []term.Value) (term.Value, error) {
+	if err := checkArity(operands, 2); err != nil {
+		return nil, err
+	}
+	x := operands[0]
+	switch coll := operands[1].(type) {
+	case term.Array:
+		for _, elem := range coll {
+			if elem.Value.Equal(x) {
+				return term.Boolean(true), nil
+			}
+		}
+		return term.Boolean(false), nil
+	case term.Set:
+		return term.Boolean(coll.Contains(term.NewTerm(x))), nil
+	case term.Object:
+		for _, pair := range coll {
+			if pair[1].Value.Equal(x) {
+				return term.Boolean(true), nil
+			}
+		}
+		return term.Boolean(false), nil
+	}
+	return nil, fmt.Errorf("operand 2 must be array, set or object but got %v", typeName(operands[1]))
+}
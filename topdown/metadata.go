@@ -0,0 +1,73 @@
+package topdown
+
+import (
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/compile"
+	"avidbound.com/zego/ast/term"
+)
+
+// evalFunctions are the built-in functions that depend on the state of the
+// evaluation rather than on their operands only.
+var evalFunctions = map[string]func(e *eval, operands []term.Value) (term.Value, error){
+	"metadata.rule":  builtinMetadataRule,
+	"metadata.chain": builtinMetadataChain,
+}
+
+func init() {
+	for name := range evalFunctions {
+		ast.RegisterBuiltin(name)
+	}
+}
+
+// builtinMetadataRule returns the annotations of the rule being evaluated, or
+// of its document if the rule has none. The result is empty outside of rules.
+func builtinMetadataRule(e *eval, operands []term.Value) (term.Value, error) {
+	if err := checkArity(operands, 0); err != nil {
+		return nil, err
+	}
+	for _, ref := range e.annotations() {
+		switch ref.Annotations.Scope {
+		case ast.AnnotationScopeRule, ast.AnnotationScopeDocument:
+			return ref.Annotations.Value()
+		}
+	}
+	return term.Object{}, nil
+}
+
+// builtinMetadataChain returns the annotations that apply to the rule being
+// evaluated from the narrowest scope to the widest one, e.g.
+// [{"path": ["zego", "a", "allow"], "annotations": {"scope": "rule"}}].
+func builtinMetadataChain(e *eval, operands []term.Value) (term.Value, error) {
+	if err := checkArity(operands, 0); err != nil {
+		return nil, err
+	}
+	chain := term.Array{}
+	for _, ref := range e.annotations() {
+		v, err := ref.Annotations.Value()
+		if err != nil {
+			return nil, err
+		}
+		path := make(term.Array, len(ref.Path))
+		for i, x := range ref.Path {
+			switch s := x.Value.(type) {
+			case term.String:
+				path[i] = x
+			default:
+				path[i] = term.StringTerm(s.String())
+			}
+		}
+		chain = append(chain, term.ObjectTerm(
+			term.Item(term.StringTerm("path"), term.NewTerm(path)),
+			term.Item(term.StringTerm("annotations"), term.NewTerm(v)),
+		))
+	}
+	return chain, nil
+}
+
+// annotations returns the annotations that apply to the rule being evaluated.
+func (e *eval) annotations() []*compile.AnnotationsRef {
+	if e.rule == nil || e.compiler == nil || e.compiler.Annotations == nil {
+		return nil
+	}
+	return e.compiler.Annotations.Chain(e.rule)
+}
@@ -0,0 +1,93 @@
+package topdown
+
+import (
+	"context"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/compile"
+	"avidbound.com/zego/ast/term"
+)
+
+// Query evaluates a compiled query against the rules of a compiler.
+type Query struct {
+	query    ast.Body
+	compiler *compile.Compiler
+	input    *term.Term
+}
+
+// QueryResult holds a single solution of the query.
+type QueryResult struct {
+	// Bindings maps the variables of the query to their values.
+	Bindings map[term.Var]*term.Term
+
+	// Expressions holds the value of each expression of the query, in order.
+	Expressions []*term.Term
+}
+
+// NewQuery returns a new Query for the compiled query body.
+func NewQuery(query ast.Body) *Query {
+	return &Query{
+		query: query,
+	}
+}
+
+// WithCompiler sets the compiler holding the rules the query refers to.
+func (q *Query) WithCompiler(compiler *compile.Compiler) *Query {
+	q.compiler = compiler
+	return q
+}
+
+// WithInput sets the input document.
+func (q *Query) WithInput(input *term.Term) *Query {
+	q.input = input
+	return q
+}
+
+// Iter evaluates the query and calls iter with the bindings of each solution.
+func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
+	e := &eval{
+		ctx:      ctx,
+		compiler: q.compiler,
+		input:    q.input,
+		bindings: newBindings(),
+		virtual:  map[*compile.TreeNode]term.Value{},
+		active:   map[*compile.TreeNode]bool{},
+	}
+
+	vars := []term.Var{}
+	seen := map[term.Var]bool{}
+	ast.WalkVars(q.query, func(v term.Var) {
+		if !seen[v] {
+			seen[v] = true
+			vars = append(vars, v)
+		}
+	})
+
+	values := make([]term.Value, len(q.query))
+
+	return e.evalQuery(q.query, values, 0, func() error {
+		qr := QueryResult{
+			Bindings:    map[term.Var]*term.Term{},
+			Expressions: make([]*term.Term, len(values)),
+		}
+		for _, v := range vars {
+			if x, ok := e.bindings.get(v); ok {
+				qr.Bindings[v] = term.NewTerm(x)
+			}
+		}
+		for i, x := range values {
+			qr.Expressions[i] = term.NewTerm(x).SetLoc(q.query[i].Location)
+		}
+		return iter(qr)
+	})
+}
+
+// Run evaluates the query and returns the bindings of every solution.
+func (q *Query) Run(ctx context.Context) ([]QueryResult, error) {
+	var qrs []QueryResult
+	err := q.Iter(ctx, func(qr QueryResult) error {
+		qrs = append(qrs, qr)
+		return nil
+	})
+	return qrs, err
+}
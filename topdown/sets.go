@@ -0,0 +1,43 @@
+package topdown
+
+import (
+	"fmt"
+
+	"avidbound.com/zego/ast/term"
+)
+
+func builtinSet(f func(a, b term.Set) term.Set) BuiltinFunc {
+	return func(operands []term.Value) (term.Value, error) {
+		if err := checkArity(operands, 2); err != nil {
+			return nil, err
+		}
+		a, err := setOperand(operands, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := setOperand(operands, 1)
+		if err != nil {
+			return nil, err
+		}
+		return f(a, b), nil
+	}
+}
+
+// builtinMinus is the difference of two sets or the subtraction of two numbers.
+func builtinMinus(operands []term.Value) (term.Value, error) {
+	if err := checkArity(operands, 2); err != nil {
+		return nil, err
+	}
+	if _, ok := operands[0].(term.Set); ok {
+		return builtinSet(term.Set.Diff)(operands)
+	}
+	return builtinArithmetic(arithSubtract)(operands)
+}
+
+func setOperand(operands []term.Value, i int) (term.Set, error) {
+	s, ok := operands[i].(term.Set)
+	if !ok {
+		return nil, fmt.Errorf("operand %d must be set but got %v", i+1, typeName(operands[i]))
+	}
+	return s, nil
+}
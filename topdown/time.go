@@ -0,0 +1,16 @@
+package topdown
+
+import (
+	"strconv"
+	"time"
+
+	"avidbound.com/zego/ast/term"
+)
+
+// builtinNowNs returns the current time in nanoseconds since the Unix epoch.
+func builtinNowNs(operands []term.Value) (term.Value, error) {
+	if err := checkArity(operands, 0); err != nil {
+		return nil, err
+	}
+	return term.Number(strconv.FormatInt(time.Now().UnixNano(), 10)), nil
+}
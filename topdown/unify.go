@@ -0,0 +1,151 @@
+package topdown
+
+import (
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+// unifyArgs unifies the arguments of a function with the operands of the call
+// from index onwards and calls iter once all of them unify.
+func (e *eval) unifyArgs(args ast.Args, operands []term.Value, index int, iter func() error) error {
+	if index == len(args) {
+		return iter()
+	}
+	return e.unify(args[index], operands[index], func() error {
+		return e.unifyArgs(args, operands, index+1, iter)
+	})
+}
+
+// unifyTerms unifies the terms a and b, binding the unbound variables of either
+// side for the duration of iter. Arrays and objects of the same shape are
+// unified element by element, otherwise the side that contains unbound
+// variables is unified with the value of the other side.
+func (e *eval) unifyTerms(a, b *term.Term, iter func() error) error {
+	switch x := a.Value.(type) {
+	case term.Array:
+		if y, ok := b.Value.(term.Array); ok && len(x) == len(y) {
+			return e.unifyTermSlice(x, y, 0, iter)
+		}
+	case term.Object:
+		if y, ok := b.Value.(term.Object); ok && len(x) == len(y) {
+			if as, bs, ok := objectValues(x, y); ok {
+				return e.unifyTermSlice(as, bs, 0, iter)
+			}
+		}
+	}
+
+	if e.bound(a) {
+		return e.evalTerm(a, func(v term.Value) error {
+			return e.unify(b, v, iter)
+		})
+	}
+	return e.evalTerm(b, func(v term.Value) error {
+		return e.unify(a, v, iter)
+	})
+}
+
+func (e *eval) unifyTermSlice(a, b []*term.Term, index int, iter func() error) error {
+	if index == len(a) {
+		return iter()
+	}
+	return e.unifyTerms(a[index], b[index], func() error {
+		return e.unifyTermSlice(a, b, index+1, iter)
+	})
+}
+
+// bound returns true if none of the variables that unification could bind in t
+// are unbound.
+func (e *eval) bound(t *term.Term) bool {
+	switch v := t.Value.(type) {
+	case term.Var:
+		_, ok := e.unboundVar(t)
+		return !ok
+	case term.Array:
+		for _, x := range v {
+			if !e.bound(x) {
+				return false
+			}
+		}
+	case term.Object:
+		for _, pair := range v {
+			if !e.bound(pair[1]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// objectValues returns the values of a and b paired by key. The keys of a must
+// be ground and each of them must be a key of b.
+func objectValues(a, b term.Object) ([]*term.Term, []*term.Term, bool) {
+	as := make([]*term.Term, 0, len(a))
+	bs := make([]*term.Term, 0, len(a))
+	for _, pair := range a {
+		if !term.IsGround(pair[0].Value) {
+			return nil, nil, false
+		}
+		x := b.Get(pair[0])
+		if x == nil {
+			return nil, nil, false
+		}
+		as = append(as, pair[1])
+		bs = append(bs, x)
+	}
+	return as, bs, true
+}
+
+// unify unifies the term a with the value b, binding the unbound variables of a
+// to the matching parts of b for the duration of iter. Arrays and objects are
+// unified element by element.
+func (e *eval) unify(a *term.Term, b term.Value, iter func() error) error {
+	switch v := a.Value.(type) {
+	case term.Var:
+		if x, ok := e.unboundVar(a); ok {
+			return e.bindings.bind(x, b, iter)
+		}
+	case term.Array:
+		arr, ok := b.(term.Array)
+		if !ok || len(arr) != len(v) {
+			return nil
+		}
+		return e.unifyArray(v, arr, 0, iter)
+	case term.Object:
+		obj, ok := b.(term.Object)
+		if !ok || len(obj) != len(v) {
+			return nil
+		}
+		return e.unifyObject(v, obj, 0, iter)
+	}
+
+	return e.evalTerm(a, func(x term.Value) error {
+		if !x.Equal(b) {
+			return nil
+		}
+		return iter()
+	})
+}
+
+func (e *eval) unifyArray(a, b term.Array, index int, iter func() error) error {
+	if index == len(a) {
+		return iter()
+	}
+	return e.unify(a[index], b[index].Value, func() error {
+		return e.unifyArray(a, b, index+1, iter)
+	})
+}
+
+func (e *eval) unifyObject(a, b term.Object, index int, iter func() error) error {
+	if index == len(a) {
+		return iter()
+	}
+	return e.evalTerm(a[index][0], func(k term.Value) error {
+		x := b.Get(term.NewTerm(k))
+		if x == nil {
+			return nil
+		}
+		return e.unify(a[index][1], x.Value, func() error {
+			return e.unifyObject(a, b, index+1, iter)
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package topdown
+
+import (
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/compile"
+	"avidbound.com/zego/ast/term"
+)
+
+// mock replaces a function while a with modifier applies, either by another
+// function or by a constant value.
+type mock struct {
+	op    *term.Term
+	value term.Value
+}
+
+// evalWith evaluates the value of each modifier from index onwards and calls
+// iter with a copy of cpy in which the targets are replaced by the values.
+// Values are evaluated in the scope of e.
+func (e *eval) evalWith(mods []*ast.With, index int, cpy *eval, iter func(*eval) error) error {
+	if index == len(mods) {
+		return iter(cpy)
+	}
+
+	w := mods[index]
+	if e.isFunction(w.Value) {
+		next := cpy.withMock(w.Target, mock{op: w.Value})
+		return e.evalWith(mods, index+1, next, iter)
+	}
+
+	return e.evalTerm(w.Value, func(v term.Value) error {
+		next, err := cpy.withValue(w.Target, v)
+		if err != nil {
+			return err
+		}
+		return e.evalWith(mods, index+1, next, iter)
+	})
+}
+
+// modified returns a copy of e for evaluating an expression with modifiers.
+// Rules are evaluated again as their values may depend on the modifiers.
+func (e *eval) modified() *eval {
+	cpy := *e
+	cpy.virtual = map[*compile.TreeNode]term.Value{}
+	return &cpy
+}
+
+// withValue returns a copy of e in which the document or function referred to
+// by target is replaced by v.
+func (e *eval) withValue(target *term.Term, v term.Value) (*eval, error) {
+	ref, ok := target.Value.(term.Ref)
+	if !ok {
+		ref = term.Ref{target}
+	}
+
+	switch {
+	case ref[0].Value.Equal(ast.InputRootDocument.Value):
+		cpy := *e
+		var input term.Value
+		if e.input != nil {
+			input = e.input.Value
+		}
+		cpy.input = term.NewTerm(upsert(input, ref[1:], v))
+		return &cpy, nil
+	case ref[0].Value.Equal(ast.RootDocument.Value):
+		node, rest := e.compiler.RuleTree.Find(ref)
+		if len(node.Values) > 0 && node.Values[0].IsFunction() {
+			return e.withMock(target, mock{value: v}), nil
+		}
+		if len(rest) > 0 {
+			base, err := e.document(node)
+			if err != nil {
+				return nil, err
+			}
+			v = upsert(base, rest, v)
+		}
+		cpy := *e
+		cpy.data = make(map[*compile.TreeNode]term.Value, len(e.data)+1)
+		for k, x := range e.data {
+			cpy.data[k] = x
+		}
+		cpy.data[node] = v
+		return &cpy, nil
+	}
+
+	return e.withMock(target, mock{value: v}), nil
+}
+
+// withMock returns a copy of e in which the function named by target is
+// replaced by m.
+func (e *eval) withMock(target *term.Term, m mock) *eval {
+	cpy := *e
+	cpy.mocks = make(map[string]mock, len(e.mocks)+1)
+	for k, x := range e.mocks {
+		cpy.mocks[k] = x
+	}
+	cpy.mocks[target.String()] = m
+	return &cpy
+}
+
+// document returns the value of the document at node or nil if the document
+// is undefined.
+func (e *eval) document(node *compile.TreeNode) (term.Value, error) {
+	if v, ok := e.data[node]; ok {
+		return v, nil
+	}
+	if len(node.Values) == 0 {
+		return nil, nil
+	}
+	return e.evalRule(node)
+}
+
+// isFunction returns true if t names a user function or a built-in function.
+func (e *eval) isFunction(t *term.Term) bool {
+	switch t.Value.(type) {
+	case term.Ref, term.Var:
+	default:
+		return false
+	}
+	if e.function(t) != nil {
+		return true
+	}
+	_, ok := builtinFunctions[t.String()]
+	return ok
+}
+
+// upsert returns a copy of v with the value at path set to x. Objects are
+// created along the path where v has no value.
+func upsert(v term.Value, path term.Ref, x term.Value) term.Value {
+	if len(path) == 0 {
+		return x
+	}
+	obj, ok := v.(term.Object)
+	if !ok {
+		obj = term.Object{}
+	}
+	var child term.Value
+	if t := obj.Get(path[0]); t != nil {
+		child = t.Value
+	}
+	return obj.Insert(path[0], term.NewTerm(upsert(child, path[1:], x)))
+}
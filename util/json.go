@@ -0,0 +1,30 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// UnmarshalJSON parses the JSON encoded data and stores the result in the value
+// pointed to by x. Numbers are decoded as json.Number so that no precision is
+// lost.
+func UnmarshalJSON(bs []byte, x interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.UseNumber()
+	return decoder.Decode(x)
+}
+
+// RoundTrip encodes x to JSON and decodes it again, normalizing x into maps,
+// slices, strings, booleans and json.Number values.
+func RoundTrip(x *interface{}) error {
+	bs, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := UnmarshalJSON(bs, &doc); err != nil {
+		return err
+	}
+	*x = doc
+	return nil
+}
@@ -0,0 +1,63 @@
+package zego
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"avidbound.com/zego/ast/term"
+	"avidbound.com/zego/util"
+)
+
+// convertInput converts the input given to EvalInput into a term. Go values
+// are first normalized into the maps, slices, strings, booleans and
+// json.Number values produced by encoding/json.
+func convertInput(x interface{}) (*term.Term, error) {
+	switch x := x.(type) {
+	case *term.Term:
+		if x == nil {
+			return nil, fmt.Errorf("input: nil term")
+		}
+		if !term.IsGround(x.Value) {
+			return nil, fmt.Errorf("input: %v is not ground", x)
+		}
+		return x, nil
+	case json.RawMessage:
+		return decodeInput(bytes.NewReader(x))
+	case []byte:
+		return decodeInput(bytes.NewReader(x))
+	case io.Reader:
+		return decodeInput(x)
+	}
+
+	if err := util.RoundTrip(&x); err != nil {
+		return nil, fmt.Errorf("input: unsupported value: %v", err)
+	}
+	return nativeToTerm(x)
+}
+
+// decodeInput decodes a single JSON document from r.
+func decodeInput(r io.Reader) (*term.Term, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var x interface{}
+	if err := decoder.Decode(&x); err != nil {
+		return nil, fmt.Errorf("input: invalid JSON: %v", err)
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("input: invalid JSON: unexpected data after document")
+	}
+
+	return nativeToTerm(x)
+}
+
+func nativeToTerm(x interface{}) (*term.Term, error) {
+	v, err := term.InterfaceToValue(x)
+	if err != nil {
+		return nil, fmt.Errorf("input: %v", err)
+	}
+	return term.NewTerm(v), nil
+}
@@ -0,0 +1,85 @@
+package zego
+
+import (
+	"fmt"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+	"avidbound.com/zego/topdown"
+)
+
+// ResultSet represents the output of a query evaluation. Each Result is a
+// single solution of the query; an empty ResultSet means the query is
+// undefined.
+type ResultSet []Result
+
+// Vars maps the names of query variables to their values.
+type Vars map[string]interface{}
+
+// Result holds a single solution of a query.
+type Result struct {
+	Expressions []*ExpressionValue `json:"expressions"`
+	Bindings    Vars               `json:"bindings,omitempty"`
+}
+
+// ExpressionValue holds the value of one of the expressions of the query
+// along with the text of the expression and its location in the query.
+type ExpressionValue struct {
+	Value    interface{}    `json:"value"`
+	Text     string         `json:"text"`
+	Location *term.Location `json:"location"`
+}
+
+func (ev *ExpressionValue) String() string {
+	return fmt.Sprint(ev.Value)
+}
+
+// Allowed returns true if the result set holds a single result with a single
+// expression whose value is true and no bindings, e.g. for the query
+// zego.authz.allow.
+func (rs ResultSet) Allowed() bool {
+	if len(rs) != 1 || len(rs[0].Expressions) != 1 || len(rs[0].Bindings) > 0 {
+		return false
+	}
+	b, ok := rs[0].Expressions[0].Value.(bool)
+	return ok && b
+}
+
+func newResult(body ast.Body, qr topdown.QueryResult) (Result, error) {
+	result := Result{
+		Expressions: make([]*ExpressionValue, len(qr.Expressions)),
+	}
+
+	for i, t := range qr.Expressions {
+		v, err := term.ValueToInterface(t.Value)
+		if err != nil {
+			return Result{}, err
+		}
+		result.Expressions[i] = &ExpressionValue{
+			Value:    v,
+			Text:     exprText(body, i),
+			Location: t.Location,
+		}
+	}
+
+	if len(qr.Bindings) > 0 {
+		result.Bindings = Vars{}
+		for k, t := range qr.Bindings {
+			v, err := term.ValueToInterface(t.Value)
+			if err != nil {
+				return Result{}, err
+			}
+			result.Bindings[string(k)] = v
+		}
+	}
+
+	return result, nil
+}
+
+// exprText returns the source text of the expression at index in the query.
+func exprText(body ast.Body, index int) string {
+	if loc := body[index].Location; loc != nil {
+		return string(loc.Text)
+	}
+	return ""
+}
@@ -7,6 +7,7 @@ import (
 	"avidbound.com/zego/ast"
 	"avidbound.com/zego/ast/compile"
 	"avidbound.com/zego/ast/parser"
+	"avidbound.com/zego/topdown"
 )
 
 type Zego struct {
@@ -15,6 +16,7 @@ type Zego struct {
 	modules       []rawModule
 	parsedModules map[string]*ast.Module
 	parsedQuery   ast.Body
+	compiledQuery ast.Body
 }
 
 type Option func(r *Zego)
@@ -59,6 +61,8 @@ func Query(q string) func(r *Zego) {
 }
 
 type PreparedEvalQuery struct {
+	compiler *compile.Compiler
+	query    ast.Body
 }
 
 // PrepareForEval will parse inputs, modules, and query arguments in preparation
@@ -68,9 +72,14 @@ func (r *Zego) PrepareForEval(ctx context.Context) (PreparedEvalQuery, error) {
 		return PreparedEvalQuery{}, fmt.Errorf("cannot evaluate empty query")
 	}
 
-	err := r.prepare(ctx)
+	if err := r.prepare(ctx); err != nil {
+		return PreparedEvalQuery{}, err
+	}
 
-	return PreparedEvalQuery{}, err
+	return PreparedEvalQuery{
+		compiler: r.compiler,
+		query:    r.compiledQuery,
+	}, nil
 }
 
 func (r *Zego) prepare(ctx context.Context) error {
@@ -108,11 +117,20 @@ func (r *Zego) parseModules(ctx context.Context) error {
 	for _, module := range r.modules {
 		p, err := module.Parse()
 		if err != nil {
-			errs = append(errs, err)
+			if e, ok := err.(ast.Errors); ok {
+				errs = append(errs, e...)
+			} else {
+				errs = append(errs, err)
+			}
+			continue
 		}
 		r.parsedModules[module.filename] = p
 	}
 
+	if len(errs) > 0 {
+		return errs
+	}
+
 	return nil
 }
 
@@ -126,21 +144,21 @@ func (r *Zego) parseQuery() (ast.Body, error) {
 
 func (r *Zego) compileModules(ctx context.Context) error {
 
-	if len(r.parsedModules) > 0 {
-		if r.compiler.Compile(r.parsedModules); r.compiler.Failed() {
-			return r.compiler.Errors
-		}
+	if r.compiler.Compile(r.parsedModules); r.compiler.Failed() {
+		return r.compiler.Errors
 	}
 
 	return nil
 }
 
 func (r *Zego) compileAndCacheQuery() error {
-	_, _, err := r.compileQuery()
+	_, compiled, err := r.compileQuery()
 	if err != nil {
 		return err
 	}
 
+	r.compiledQuery = compiled
+
 	return nil
 }
 
@@ -152,8 +170,47 @@ func (r *Zego) compileQuery() (compile.QueryCompiler, ast.Body, error) {
 	return qc, compiled, err
 }
 
+// Annotations returns the annotations of the modules' packages and rules
+// sorted by the path they apply to.
+func (q *PreparedEvalQuery) Annotations() []*compile.AnnotationsRef {
+	if q.compiler.Annotations == nil {
+		return nil
+	}
+	return q.compiler.Annotations.Flatten()
+}
+
+// Eval evaluates the prepared query and returns a Result for every solution.
 func (q *PreparedEvalQuery) Eval(ctx context.Context, options ...EvalOption) (ResultSet, error) {
-	return ResultSet{}, nil
+	ectx := &EvalContext{}
+	for _, o := range options {
+		o(ectx)
+	}
+
+	query := topdown.NewQuery(q.query).WithCompiler(q.compiler)
+
+	if ectx.hasInput {
+		input, err := convertInput(*ectx.rawInput)
+		if err != nil {
+			return nil, err
+		}
+		query = query.WithInput(input)
+	}
+
+	rs := ResultSet{}
+	err := query.Iter(ctx, func(qr topdown.QueryResult) error {
+		result, err := newResult(q.query, qr)
+		if err != nil {
+			return err
+		}
+		rs = append(rs, result)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rs, nil
 }
 
 type EvalContext struct {
@@ -164,12 +221,20 @@ type EvalContext struct {
 // EvalOption defines a function to set an option on an EvalConfig
 type EvalOption func(*EvalContext)
 
-// EvalInput configures the input for a Prepared Query's evaluation
+// EvalInput configures the input for a Prepared Query's evaluation. The input
+// may be given as:
+//
+//   - a Go value such as a map, slice or struct, which is converted as if it was
+//     encoded by encoding/json; struct fields honour their json tags,
+//   - a []byte or json.RawMessage holding a JSON document,
+//   - an io.Reader from which a single JSON document is read,
+//   - a *term.Term holding a ground value.
+//
+// A string is a Go value, so it is the JSON string itself; pass []byte to give
+// the input as JSON text. Eval fails if the input cannot be converted.
 func EvalInput(input interface{}) EvalOption {
 	return func(e *EvalContext) {
 		e.rawInput = &input
 		e.hasInput = true
 	}
 }
-
-type ResultSet []interface{}
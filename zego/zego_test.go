@@ -0,0 +1,215 @@
+package zego
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"avidbound.com/zego/ast"
+	"avidbound.com/zego/ast/term"
+)
+
+func TestEvalResultSet(t *testing.T) {
+	ctx := context.Background()
+
+	query, err := New(
+		Query("x := zego.test.a\nzego.test.b > 1"),
+		Module("test.zego", "package test\na := input.a\nb := 2"),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := query.Eval(ctx, EvalInput(map[string]interface{}{"a": "test"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rs) != 1 {
+		t.Fatalf("expected 1 result but got %d", len(rs))
+	}
+	if v := rs[0].Bindings["x"]; v != "test" {
+		t.Errorf("expected x to be \"test\" but got %v", v)
+	}
+
+	expressions := []struct {
+		text  string
+		value interface{}
+		line  int
+	}{
+		{"x := zego.test.a", true, 1},
+		{"zego.test.b > 1", true, 2},
+	}
+
+	if len(rs[0].Expressions) != len(expressions) {
+		t.Fatalf("expected %d expressions but got %d", len(expressions), len(rs[0].Expressions))
+	}
+	for i, e := range expressions {
+		ev := rs[0].Expressions[i]
+		if ev.Text != e.text {
+			t.Errorf("expression %d: expected text %q but got %q", i, e.text, ev.Text)
+		}
+		if ev.Value != e.value {
+			t.Errorf("expression %d: expected value %v but got %v", i, e.value, ev.Value)
+		}
+		if ev.Location == nil || ev.Location.Line != e.line {
+			t.Errorf("expression %d: expected line %d but got %v", i, e.line, ev.Location)
+		}
+	}
+
+	// separators and comments between expressions are not part of their text
+	query, err = New(
+		Query("zego.test.b > 1; zego.test.b < 3 # bounds\n\nzego.test.b == 2"),
+		Module("test.zego", "package test\nb := 2"),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err = query.Eval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	texts := []string{"zego.test.b > 1", "zego.test.b < 3", "zego.test.b == 2"}
+	if len(rs) != 1 || len(rs[0].Expressions) != len(texts) {
+		t.Fatalf("expected %d expressions but got %v", len(texts), rs)
+	}
+	for i, text := range texts {
+		if ev := rs[0].Expressions[i]; ev.Text != text {
+			t.Errorf("expression %d: expected text %q but got %q", i, text, ev.Text)
+		}
+	}
+}
+
+func TestResultSetAllowed(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"zego.test.allow", true},
+		{"zego.test.deny", false},
+		{"zego.test.missing", false},
+		{"x := zego.test.allow", false},
+	}
+
+	for _, tc := range tests {
+		query, err := New(
+			Query(tc.query),
+			Module("test.zego", "package test\nallow := true\ndeny := false"),
+		).PrepareForEval(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err := query.Eval(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rs.Allowed() != tc.expected {
+			t.Errorf("%s: expected allowed to be %v", tc.query, tc.expected)
+		}
+	}
+}
+
+func TestEvalInput(t *testing.T) {
+	ctx := context.Background()
+
+	type user struct {
+		Name  string `json:"name"`
+		Roles []string
+		Admin bool `json:"-"`
+	}
+
+	query, err := New(
+		Query("x := input.name"),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := map[string]interface{}{
+		"map":         map[string]interface{}{"name": "alice"},
+		"struct":      user{Name: "alice"},
+		"pointer":     &user{Name: "alice"},
+		"bytes":       []byte(`{"name": "alice"}`),
+		"raw message": json.RawMessage(`{"name": "alice"}`),
+		"reader":      strings.NewReader(`{"name": "alice"}`),
+	}
+
+	for name, input := range inputs {
+		rs, err := query.Eval(ctx, EvalInput(input))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if len(rs) != 1 || rs[0].Bindings["x"] != "alice" {
+			t.Errorf("%s: expected x to be \"alice\" but got %v", name, rs)
+		}
+	}
+
+	query, err = New(Query("x := input")).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := query.Eval(ctx, EvalInput(term.StringTerm("alice")))
+	if err != nil || len(rs) != 1 || rs[0].Bindings["x"] != "alice" {
+		t.Errorf("term: expected x to be \"alice\" but got %v (err: %v)", rs, err)
+	}
+
+	invalid := map[string]interface{}{
+		"channel":       make(chan int),
+		"function":      func() {},
+		"invalid bytes": []byte(`{"name": `),
+		"trailing data": strings.NewReader(`{"name": "alice"} {}`),
+		"unbound term":  term.VarTerm("x"),
+	}
+
+	for name, input := range invalid {
+		if _, err := query.Eval(ctx, EvalInput(input)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPrepareParseErrors(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := New(
+		Query("x := zego.a.b"),
+		Module("a.zego", "package a\nb := 1 +\nc := [1\n"),
+		Module("b.zego", "package b\nd := $"),
+	).PrepareForEval(ctx)
+
+	errs, ok := err.(ast.Errors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expected 3 parse errors but got %v", err)
+	}
+}
+
+func TestPreparedEvalQueryAnnotations(t *testing.T) {
+	ctx := context.Background()
+
+	pq, err := New(
+		Query("x := zego.a.allow"),
+		Module("a.zego", "# METADATA\n# title: A\npackage a\n\n# METADATA\n# title: Allow\nallow := true\n"),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, ref := range pq.Annotations() {
+		actual = append(actual, ref.Path.String()+":"+ref.Annotations.Title)
+	}
+
+	expected := "zego.a:A zego.a.allow:Allow"
+	if strings.Join(actual, " ") != expected {
+		t.Fatalf("expected annotations %q but got %q", expected, strings.Join(actual, " "))
+	}
+}